@@ -0,0 +1,445 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// AttachmentBundleName is the filename used for the zip bundle created
+// when the attachments exceed the configured maximum size.
+const AttachmentBundleName = "attachments.zip"
+
+// attach adds the files to the email. When a maximum size is configured
+// and the encoded files exceed it, they are bundled into a single compressed
+// zip archive instead. An error listing each file's size is returned when
+// the encoded archive still exceeds the maximum size.
+func (p *Plugin) attach(files []string) error {
+	logrus.Trace("entered plugin.attach")
+	defer logrus.Trace("exited plugin.attach")
+
+	maxSize, err := parseSize(p.AttachmentMaxSize)
+	if err != nil {
+		return err
+	}
+
+	sizes := make([]int64, len(files))
+
+	var total int64
+
+	for i, file := range files {
+		fileInfo, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+
+		sizes[i] = fileInfo.Size()
+		total += encodedSize(sizes[i])
+	}
+
+	if maxSize == 0 || total <= maxSize {
+		for _, file := range files {
+			logrus.Debugf("Attaching %s...", file)
+
+			if _, err := p.Email.AttachFile(file); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	logrus.Infof("Encoded attachments total %s which exceeds %s, bundling into %s...",
+		formatSize(total), formatSize(maxSize), AttachmentBundleName)
+
+	bundle, err := zipFiles(files)
+	if err != nil {
+		return err
+	}
+
+	if encoded := encodedSize(int64(len(bundle))); encoded > maxSize {
+		details := make([]string, len(files))
+		for i, file := range files {
+			details[i] = fmt.Sprintf("%s (%s)", file, formatSize(sizes[i]))
+		}
+
+		return fmt.Errorf("%w: %s bundle is %s encoded, limit is %s: %s", ErrorAttachmentsTooLarge,
+			AttachmentBundleName, formatSize(encoded), formatSize(maxSize), strings.Join(details, ", "))
+	}
+
+	logrus.Debugf("Attaching %s (%s)...", AttachmentBundleName, formatSize(int64(len(bundle))))
+
+	_, err = p.Email.Attach(bytes.NewReader(bundle), AttachmentBundleName, "application/zip")
+
+	return err
+}
+
+// embed adds the files to the email as inline parts of a
+// multipart/related HTML body. Each file is given a Content-ID
+// matching its filename so templates can reference it as cid:<name>.
+func (p *Plugin) embed(files []string) error {
+	logrus.Trace("entered plugin.embed")
+	defer logrus.Trace("exited plugin.embed")
+
+	seen := map[string]string{}
+
+	for _, file := range files {
+		name := filepath.Base(file)
+
+		if other, ok := seen[name]; ok {
+			return fmt.Errorf("%w: %s and %s are both cid:%s", ErrorDuplicateInlineImage, other, file, name)
+		}
+
+		seen[name] = file
+
+		logrus.Debugf("Embedding %s as cid:%s...", file, name)
+
+		attachment, err := p.Email.AttachFile(file)
+		if err != nil {
+			return err
+		}
+
+		attachment.HTMLRelated = true
+		attachment.Header.Set("Content-ID", fmt.Sprintf("<%s>", name))
+	}
+
+	return nil
+}
+
+// encodedSize returns the size of an attachment once it is base64 encoded
+// in lines of 76 characters, which is what relays compare to their limits.
+func encodedSize(size int64) int64 {
+	encoded := (size + 2) / 3 * 4
+
+	return encoded + (encoded+75)/76*2
+}
+
+// zipFiles returns a compressed zip archive containing the files.
+func zipFiles(files []string) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+
+	archive := zip.NewWriter(buffer)
+
+	for _, file := range files {
+		if err := zipFile(archive, file); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// zipFile writes a single file into the zip archive using
+// its workspace relative path as the entry name.
+func zipFile(archive *zip.Writer, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fileInfo, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	header, err := zip.FileInfoHeader(fileInfo)
+	if err != nil {
+		return err
+	}
+
+	// strip any leading volume, root or parent directory
+	// references so the archive extracts in place
+	name := filepath.ToSlash(filepath.Clean(file))
+	name = strings.TrimPrefix(name, filepath.VolumeName(file))
+
+	for strings.HasPrefix(name, "/") || strings.HasPrefix(name, "../") {
+		name = strings.TrimPrefix(strings.TrimPrefix(name, "/"), "../")
+	}
+
+	header.Name = name
+	header.Method = zip.Deflate
+
+	w, err := archive.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, f)
+
+	return err
+}
+
+// parseSize converts a human readable size such as "10MB" or
+// "512KiB" into bytes. Units are powers of 1024 and an empty
+// string returns zero to indicate no limit.
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if len(s) == 0 {
+		return 0, nil
+	}
+
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"KIB", 1 << 10},
+		{"MIB", 1 << 20},
+		{"GIB", 1 << 30},
+		{"KB", 1 << 10},
+		{"MB", 1 << 20},
+		{"GB", 1 << 30},
+		{"K", 1 << 10},
+		{"M", 1 << 20},
+		{"G", 1 << 30},
+		{"B", 1},
+	}
+
+	multiplier := int64(1)
+
+	for _, unit := range units {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			multiplier = unit.multiplier
+
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("%w: %s", ErrorInvalidSize, s)
+	}
+
+	// float64(math.MaxInt64) rounds up, so equal sizes overflow as well
+	size := value * float64(multiplier)
+	if size >= math.MaxInt64 {
+		return 0, fmt.Errorf("%w: %s is too large", ErrorInvalidSize, s)
+	}
+
+	return int64(size), nil
+}
+
+// formatSize converts bytes into a human readable size.
+func formatSize(size int64) string {
+	const unit = 1024
+
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// expandAttachments resolves the provided attachment paths and glob
+// patterns into a de-duplicated list of files. Literal paths must
+// exist and be non-empty, while patterns must match at least one file.
+func expandAttachments(patterns []string) ([]string, error) {
+	logrus.Trace("entered plugin.expandAttachments")
+	defer logrus.Trace("exited plugin.expandAttachments")
+
+	var files []string
+
+	seen := map[string]bool{}
+
+	for _, pattern := range patterns {
+		if len(pattern) == 0 {
+			continue
+		}
+
+		matches, err := expandAttachment(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, match := range matches {
+			if seen[match] {
+				continue
+			}
+
+			seen[match] = true
+
+			files = append(files, match)
+		}
+	}
+
+	return files, nil
+}
+
+// expandAttachment resolves a single attachment path or glob pattern.
+func expandAttachment(pattern string) ([]string, error) {
+	if !isGlob(pattern) {
+		fileInfo, err := os.Stat(pattern)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("attachment %s: %w", pattern, os.ErrNotExist)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !fileInfo.Mode().IsRegular() {
+			return nil, fmt.Errorf("attachment %s: %w", pattern, ErrorNotRegularFile)
+		}
+
+		if fileInfo.Size() == 0 {
+			return nil, fmt.Errorf("attachment %s: %w", pattern, ErrorEmptyFile)
+		}
+
+		return []string{pattern}, nil
+	}
+
+	matches, err := glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("attachment pattern %s: %w", pattern, err)
+	}
+
+	var files []string
+
+	for _, match := range matches {
+		fileInfo, err := os.Stat(match)
+		if err != nil {
+			return nil, err
+		}
+
+		if !fileInfo.Mode().IsRegular() {
+			continue
+		}
+
+		if fileInfo.Size() == 0 {
+			logrus.Warnf("Skipping empty attachment %s matched by %s", match, pattern)
+
+			continue
+		}
+
+		files = append(files, match)
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrorAttachmentNoMatch, pattern)
+	}
+
+	logrus.Debugf("Attachment pattern %s matched %d file(s)", pattern, len(files))
+
+	return files, nil
+}
+
+// glob returns the files matching the pattern. In addition to the
+// syntax supported by filepath.Match, a "**" path segment matches
+// zero or more directories.
+func glob(pattern string) ([]string, error) {
+	pattern = filepath.Clean(pattern)
+
+	segments := strings.Split(filepath.ToSlash(pattern), "/")
+
+	// validate every segment up front so a bad pattern
+	// is reported even when the walk finds nothing
+	for _, segment := range segments {
+		if _, err := filepath.Match(segment, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	if !strings.Contains(pattern, "**") {
+		return filepath.Glob(pattern)
+	}
+
+	// walk from the longest directory prefix without any meta characters
+	var static []string
+
+	for _, segment := range segments {
+		if isGlob(segment) {
+			break
+		}
+
+		static = append(static, segment)
+	}
+
+	root := strings.Join(static, "/")
+
+	switch {
+	case len(static) == 0:
+		root = "."
+	case len(root) == 0:
+		root = "/"
+	}
+
+	var matches []string
+
+	err := filepath.WalkDir(filepath.FromSlash(root), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return fs.SkipDir
+			}
+
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		if matchSegments(segments, strings.Split(filepath.ToSlash(path), "/")) {
+			matches = append(matches, path)
+		}
+
+		return nil
+	})
+
+	return matches, err
+}
+
+// matchSegments reports whether the path segments match the pattern segments.
+func matchSegments(pattern, path []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(path); i++ {
+				if matchSegments(pattern[1:], path[i:]) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		if len(path) == 0 {
+			return false
+		}
+
+		ok, err := filepath.Match(pattern[0], path[0])
+		if err != nil || !ok {
+			return false
+		}
+
+		pattern, path = pattern[1:], path[1:]
+	}
+
+	return len(path) == 0
+}
+
+// isGlob reports whether the string contains any glob meta characters.
+func isGlob(s string) bool {
+	return strings.ContainsAny(s, `*?[\`)
+}
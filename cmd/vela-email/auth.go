@@ -0,0 +1,116 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"net/smtp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// authPreference is the order mechanisms are chosen in
+// by auto authentication, from strongest to weakest.
+var authPreference = []string{"OAUTHBEARER", "XOAUTH2", "CRAM-MD5", "PLAIN", "LOGIN"}
+
+// autoAuth struct contains the credentials for every mechanism
+// and the mechanism selected from the server's AUTH capability.
+type autoAuth struct {
+	username, password, token string
+	relay                     Relay
+	selected                  smtp.Auth
+}
+
+// AutoAuth returns an Auth that negotiates the strongest mechanism
+// supported by both the server and the provided credentials.
+func AutoAuth(username, password, token string, relay Relay) smtp.Auth {
+	return &autoAuth{username: username, password: password, token: token, relay: relay}
+}
+
+// Start selects the mechanism from the ones advertised by
+// the server and begins the authentication with it.
+func (a *autoAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	offered := map[string]bool{}
+	for _, mechanism := range server.Auth {
+		offered[strings.ToUpper(mechanism)] = true
+	}
+
+	for _, mechanism := range authPreference {
+		if !offered[mechanism] {
+			continue
+		}
+
+		auth := a.mechanism(mechanism)
+		if auth == nil {
+			continue
+		}
+
+		logrus.Infof("Using %s authentication negotiated with %s (offered: %s)...",
+			mechanism, a.relay, strings.Join(server.Auth, " "))
+
+		a.selected = auth
+
+		return auth.Start(server)
+	}
+
+	return "", nil, fmt.Errorf("%w: %s offers %s", ErrorNoAuthMechanism, a.relay, strings.Join(server.Auth, " "))
+}
+
+// Next continues the authentication with the selected mechanism.
+func (a *autoAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	return a.selected.Next(fromServer, more)
+}
+
+// mechanism returns the Auth for the mechanism or
+// nil when the credentials it requires are missing.
+func (a *autoAuth) mechanism(mechanism string) smtp.Auth {
+	hasPassword := len(a.username) > 0 && len(a.password) > 0
+	hasToken := len(a.username) > 0 && len(a.token) > 0
+
+	switch {
+	case mechanism == "OAUTHBEARER" && hasToken:
+		return OAuthBearerAuth(a.username, a.token, a.relay.Host, a.relay.Port)
+	case mechanism == "XOAUTH2" && hasToken:
+		return XOAuth2Auth(a.username, a.token, a.relay.Host)
+	case mechanism == "CRAM-MD5" && hasPassword:
+		return smtp.CRAMMD5Auth(a.username, a.password)
+	case mechanism == "PLAIN" && hasPassword:
+		return smtp.PlainAuth("", a.username, a.password, a.relay.Host)
+	case mechanism == "LOGIN" && hasPassword:
+		return LoginAuth(a.username, a.password)
+	default:
+		return nil
+	}
+}
+
+// secureAuth struct wraps an Auth to refuse sending credentials
+// over an unencrypted connection unless it is explicitly allowed.
+type secureAuth struct {
+	smtp.Auth
+	allowInsecure bool
+}
+
+// SecureAuth returns an Auth that only starts the wrapped Auth over TLS
+// or to localhost, unless sending credentials insecurely is allowed.
+func SecureAuth(auth smtp.Auth, allowInsecure bool) smtp.Auth {
+	return &secureAuth{Auth: auth, allowInsecure: allowInsecure}
+}
+
+// Start checks the connection before starting the wrapped Auth.
+func (a *secureAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		if !a.allowInsecure {
+			return "", nil, fmt.Errorf("%w to %s", ErrorInsecureAuth, server.Name)
+		}
+
+		logrus.Warnf("Sending credentials to %s over an unencrypted connection", server.Name)
+
+		// the wrapped mechanisms perform the same check, which has been explicitly overridden
+		insecure := *server
+		insecure.TLS = true
+		server = &insecure
+	}
+
+	return a.Auth.Start(server)
+}
@@ -0,0 +1,118 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"errors"
+	"net/smtp"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jordan-wright/email"
+)
+
+func TestAutoAuth(t *testing.T) {
+	relay := Relay{Host: "smtp.example.com", Port: "587", SendType: "StartTLS"}
+
+	tests := []struct {
+		name      string
+		offered   []string
+		password  string
+		token     string
+		wantProto string
+		wantErr   error
+	}{
+		{name: "prefers cram-md5 over plain and login", offered: []string{"LOGIN", "PLAIN", "CRAM-MD5"}, password: "shh", wantProto: "CRAM-MD5"},
+		{name: "prefers plain over login", offered: []string{"login", "plain"}, password: "shh", wantProto: "PLAIN"},
+		{name: "falls back to login", offered: []string{"LOGIN"}, password: "shh", wantProto: "LOGIN"},
+		{name: "prefers oauthbearer with a token", offered: []string{"PLAIN", "XOAUTH2", "OAUTHBEARER"}, password: "shh", token: "token", wantProto: "OAUTHBEARER"},
+		{name: "skips oauth without a token", offered: []string{"XOAUTH2", "PLAIN"}, password: "shh", wantProto: "PLAIN"},
+		{name: "skips password mechanisms without a password", offered: []string{"PLAIN", "XOAUTH2"}, token: "token", wantProto: "XOAUTH2"},
+		{name: "no common mechanism", offered: []string{"GSSAPI", "NTLM"}, password: "shh", wantErr: ErrorNoAuthMechanism},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			auth := AutoAuth("vela@example.com", test.password, test.token, relay)
+
+			proto, _, err := auth.Start(&smtp.ServerInfo{Name: relay.Host, TLS: true, Auth: test.offered})
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("Start() error = %v, wantErr = %v", err, test.wantErr)
+			}
+
+			if proto != test.wantProto {
+				t.Errorf("Start() is %s, want %s", proto, test.wantProto)
+			}
+		})
+	}
+}
+
+func TestSecureAuth(t *testing.T) {
+	tests := []struct {
+		name          string
+		auth          string
+		server        *smtp.ServerInfo
+		allowInsecure bool
+		wantProto     string
+		wantErr       error
+	}{
+		{name: "tls", auth: "PlainAuth", server: &smtp.ServerInfo{Name: "smtp.example.com", TLS: true}, wantProto: "PLAIN"},
+		{name: "localhost", auth: "PlainAuth", server: &smtp.ServerInfo{Name: "localhost"}, wantProto: "PLAIN"},
+		{name: "unencrypted", auth: "PlainAuth", server: &smtp.ServerInfo{Name: "smtp.example.com"}, wantErr: ErrorInsecureAuth},
+		{name: "unencrypted allowed", auth: "PlainAuth", server: &smtp.ServerInfo{Name: "smtp.example.com"}, allowInsecure: true, wantProto: "PLAIN"},
+		{name: "login over tls", auth: "LoginAuth", server: &smtp.ServerInfo{Name: "smtp.example.com", TLS: true}, wantProto: "LOGIN"},
+		{name: "login unencrypted", auth: "LoginAuth", server: &smtp.ServerInfo{Name: "smtp.example.com"}, wantErr: ErrorInsecureAuth},
+		{name: "login unencrypted allowed", auth: "LoginAuth", server: &smtp.ServerInfo{Name: "smtp.example.com"}, allowInsecure: true, wantProto: "LOGIN"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &Plugin{
+				Auth:              test.auth,
+				AllowInsecureAuth: test.allowInsecure,
+				SMTPHost:          &SMTPHost{Username: "vela", Password: "shh"},
+			}
+
+			auth := p.smtpAuth(Relay{Host: test.server.Name, Port: "587"})
+
+			proto, _, err := auth.Start(test.server)
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("Start() error = %v, wantErr = %v", err, test.wantErr)
+			}
+
+			if proto != test.wantProto {
+				t.Errorf("Start() is %s, want %s", proto, test.wantProto)
+			}
+		})
+	}
+}
+
+func TestExecAutoAuth(t *testing.T) {
+	server := newFakeSMTP(t, func(s *fakeSMTP) { s.extensions = []string{"AUTH LOGIN PLAIN CRAM-MD5"} })
+
+	p := &Plugin{
+		Email: &email.Email{
+			To:      []string{"fakemail1@example.com"},
+			From:    "fakemail2@example.com",
+			Subject: "subject",
+			Text:    []byte("body"),
+		},
+		SMTPHost: &SMTPHost{Hosts: []string{"127.0.0.1"}, Port: server.port(), Username: "vela", Password: "shh"},
+		Auth:     "auto",
+		SendType: "Plain",
+		Timeouts: &Timeouts{Connect: time.Second, Command: time.Second},
+		BuildEnv: mockBuildEnv,
+	}
+
+	if err := p.Validate(); err != nil {
+		t.Errorf("Validate() should not have raised an error %s", err)
+	}
+
+	if err := p.Exec(); err != nil {
+		t.Errorf("Exec() should not have raised an error %s", err)
+	}
+
+	if commands, _ := server.received(); !slices.Contains(commands, "AUTH CRAM-MD5") {
+		t.Errorf("Exec() commands are %q, want CRAM-MD5 authentication", commands)
+	}
+}
@@ -0,0 +1,333 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	// BuildContext represents the build information available
+	// to templates as .Build parsed from the VELA_BUILD_* variables.
+	BuildContext struct {
+		Number       int
+		Parent       int
+		PullRequest  int
+		Status       string
+		Event        string
+		EventAction  string
+		Author       string
+		AuthorEmail  string
+		Sender       string
+		Branch       string
+		BaseRef      string
+		Ref          string
+		Tag          string
+		Target       string
+		Commit       string
+		Message      string
+		Title        string
+		Link         string
+		Clone        string
+		Source       string
+		Host         string
+		Runtime      string
+		Distribution string
+		Workspace    string
+		ApprovedBy   string
+		ApprovedAt   time.Time
+		Created      time.Time
+		Enqueued     time.Time
+		Started      time.Time
+		Finished     time.Time
+		QueueTime    time.Duration
+		RunTime      time.Duration
+		TotalTime    time.Duration
+		Duration     time.Duration
+	}
+
+	// RepoContext represents the repository information available
+	// to templates as .Repo parsed from the VELA_REPO_* variables.
+	RepoContext struct {
+		FullName     string
+		Org          string
+		Name         string
+		Owner        string
+		Link         string
+		Clone        string
+		Branch       string
+		Visibility   string
+		PipelineType string
+		Topics       []string
+		AllowEvents  []string
+		BuildLimit   int
+		Timeout      int
+		Active       bool
+		Private      bool
+		Trusted      bool
+	}
+
+	// StepContext represents the step information available
+	// to templates as .Step parsed from the VELA_STEP_* variables.
+	StepContext struct {
+		Name         string
+		Image        string
+		Stage        string
+		Status       string
+		Host         string
+		Runtime      string
+		Distribution string
+		Number       int
+		ExitCode     int
+		Created      time.Time
+		Started      time.Time
+	}
+)
+
+// DefaultTimeLayout is the layout used for the build times when none is
+// provided. It matches the output of time.Time.String for whole seconds.
+const DefaultTimeLayout = "2006-01-02 15:04:05 -0700 MST"
+
+// timeLayouts maps the names of the layouts in the time package to their values.
+var timeLayouts = map[string]string{
+	"ANSIC":       time.ANSIC,
+	"UnixDate":    time.UnixDate,
+	"RubyDate":    time.RubyDate,
+	"RFC822":      time.RFC822,
+	"RFC822Z":     time.RFC822Z,
+	"RFC850":      time.RFC850,
+	"RFC1123":     time.RFC1123,
+	"RFC1123Z":    time.RFC1123Z,
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"Kitchen":     time.Kitchen,
+	"Stamp":       time.Stamp,
+	"DateTime":    time.DateTime,
+	"DateOnly":    time.DateOnly,
+	"TimeOnly":    time.TimeOnly,
+}
+
+// TimeLayout returns the layout for the name of a layout in
+// the time package such as RFC1123, the layout itself when it
+// is not a known name or the default layout when it is empty.
+func TimeLayout(layout string) string {
+	if len(layout) == 0 {
+		return DefaultTimeLayout
+	}
+
+	if named, ok := timeLayouts[layout]; ok {
+		return named
+	}
+
+	return layout
+}
+
+// NewBuildEnv creates the user friendly build times from the unix
+// timestamps of the build. Times are formatted in the location with
+// the layout and unset timestamps are left empty. The queue, run and
+// total times are measured until now while the build is still running.
+func NewBuildEnv(created, enqueued, started, finished int64, loc *time.Location, layout string) *BuildEnv {
+	format := func(unix int64) string {
+		if unix == 0 {
+			return ""
+		}
+
+		return time.Unix(unix, 0).In(loc).Format(layout)
+	}
+
+	queue, run, total := buildDurations(unixTime(created), unixTime(enqueued), unixTime(started), unixTime(finished))
+
+	return &BuildEnv{
+		BuildCreated:   format(created),
+		BuildEnqueued:  format(enqueued),
+		BuildFinished:  format(finished),
+		BuildStarted:   format(started),
+		BuildQueueTime: formatDuration(queue),
+		BuildRunTime:   formatDuration(run),
+		BuildTotalTime: formatDuration(total),
+	}
+}
+
+// buildDurations returns the time the build spent in the queue
+// (enqueued to started), running (started to finished) and in
+// total (created to finished). While the build is running the
+// durations are measured until now.
+func buildDurations(created, enqueued, started, finished time.Time) (queue, run, total time.Duration) {
+	end := finished
+	if end.IsZero() {
+		end = time.Now()
+	}
+
+	since := func(start, end time.Time) time.Duration {
+		if start.IsZero() || end.Before(start) {
+			return 0
+		}
+
+		return end.Sub(start).Round(time.Second)
+	}
+
+	if started.IsZero() {
+		queue = since(enqueued, end)
+	} else {
+		queue = since(enqueued, started)
+		run = since(started, end)
+	}
+
+	total = since(created, end)
+
+	return queue, run, total
+}
+
+// newBuildContext parses the build variables into a BuildContext
+// with the times converted to the location.
+func newBuildContext(env map[string]string, loc *time.Location) *BuildContext {
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	b := &BuildContext{
+		Number:       envInt(env, "VELA_BUILD_NUMBER"),
+		Parent:       envInt(env, "VELA_BUILD_PARENT"),
+		PullRequest:  envInt(env, "VELA_BUILD_PULL_REQUEST"),
+		Status:       env["VELA_BUILD_STATUS"],
+		Event:        env["VELA_BUILD_EVENT"],
+		EventAction:  env["VELA_BUILD_EVENT_ACTION"],
+		Author:       env["VELA_BUILD_AUTHOR"],
+		AuthorEmail:  env["VELA_BUILD_AUTHOR_EMAIL"],
+		Sender:       env["VELA_BUILD_SENDER"],
+		Branch:       env["VELA_BUILD_BRANCH"],
+		BaseRef:      env["VELA_BUILD_BASE_REF"],
+		Ref:          env["VELA_BUILD_REF"],
+		Tag:          env["VELA_BUILD_TAG"],
+		Target:       env["VELA_BUILD_TARGET"],
+		Commit:       env["VELA_BUILD_COMMIT"],
+		Message:      env["VELA_BUILD_MESSAGE"],
+		Title:        env["VELA_BUILD_TITLE"],
+		Link:         env["VELA_BUILD_LINK"],
+		Clone:        env["VELA_BUILD_CLONE"],
+		Source:       env["VELA_BUILD_SOURCE"],
+		Host:         env["VELA_BUILD_HOST"],
+		Runtime:      env["VELA_BUILD_RUNTIME"],
+		Distribution: env["VELA_BUILD_DISTRIBUTION"],
+		Workspace:    env["VELA_BUILD_WORKSPACE"],
+		ApprovedBy:   env["VELA_BUILD_APPROVED_BY"],
+		ApprovedAt:   envTime(env, "VELA_BUILD_APPROVED_AT", loc),
+		Created:      envTime(env, "VELA_BUILD_CREATED", loc),
+		Enqueued:     envTime(env, "VELA_BUILD_ENQUEUED", loc),
+		Started:      envTime(env, "VELA_BUILD_STARTED", loc),
+		Finished:     envTime(env, "VELA_BUILD_FINISHED", loc),
+	}
+
+	b.QueueTime, b.RunTime, b.TotalTime = buildDurations(b.Created, b.Enqueued, b.Started, b.Finished)
+	b.Duration = b.RunTime
+
+	return b
+}
+
+// newRepoContext parses the repository variables into a RepoContext.
+func newRepoContext(env map[string]string) *RepoContext {
+	return &RepoContext{
+		FullName:     env["VELA_REPO_FULL_NAME"],
+		Org:          env["VELA_REPO_ORG"],
+		Name:         env["VELA_REPO_NAME"],
+		Owner:        env["VELA_REPO_OWNER"],
+		Link:         env["VELA_REPO_LINK"],
+		Clone:        env["VELA_REPO_CLONE"],
+		Branch:       env["VELA_REPO_BRANCH"],
+		Visibility:   env["VELA_REPO_VISIBILITY"],
+		PipelineType: env["VELA_REPO_PIPELINE_TYPE"],
+		Topics:       envList(env, "VELA_REPO_TOPICS"),
+		AllowEvents:  envList(env, "VELA_REPO_ALLOW_EVENTS"),
+		BuildLimit:   envInt(env, "VELA_REPO_BUILD_LIMIT"),
+		Timeout:      envInt(env, "VELA_REPO_TIMEOUT"),
+		Active:       envBool(env, "VELA_REPO_ACTIVE"),
+		Private:      envBool(env, "VELA_REPO_PRIVATE"),
+		Trusted:      envBool(env, "VELA_REPO_TRUSTED"),
+	}
+}
+
+// newStepContext parses the step variables into a StepContext
+// with the times converted to the location.
+func newStepContext(env map[string]string, loc *time.Location) *StepContext {
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	return &StepContext{
+		Name:         env["VELA_STEP_NAME"],
+		Image:        env["VELA_STEP_IMAGE"],
+		Stage:        env["VELA_STEP_STAGE"],
+		Status:       env["VELA_STEP_STATUS"],
+		Host:         env["VELA_STEP_HOST"],
+		Runtime:      env["VELA_STEP_RUNTIME"],
+		Distribution: env["VELA_STEP_DISTRIBUTION"],
+		Number:       envInt(env, "VELA_STEP_NUMBER"),
+		ExitCode:     envInt(env, "VELA_STEP_EXIT_CODE"),
+		Created:      envTime(env, "VELA_STEP_CREATED", loc),
+		Started:      envTime(env, "VELA_STEP_STARTED", loc),
+	}
+}
+
+// envInt parses the variable as an integer, returning zero when it is unset or invalid.
+func envInt(env map[string]string, key string) int {
+	value, ok := env[key]
+	if !ok || len(value) == 0 {
+		return 0
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		logrus.Debugf("Unable to parse %s=%q as an integer: %v", key, value, err)
+
+		return 0
+	}
+
+	return i
+}
+
+// envBool parses the variable as a boolean, returning false when it is unset or invalid.
+func envBool(env map[string]string, key string) bool {
+	value, ok := env[key]
+	if !ok || len(value) == 0 {
+		return false
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		logrus.Debugf("Unable to parse %s=%q as a boolean: %v", key, value, err)
+	}
+
+	return b
+}
+
+// envTime parses the variable as a unix timestamp in the location,
+// returning the zero time when it is unset, zero or invalid.
+func envTime(env map[string]string, key string, loc *time.Location) time.Time {
+	return unixTime(int64(envInt(env, key))).In(loc)
+}
+
+// unixTime returns the time of the unix timestamp or the zero time when it is zero.
+func unixTime(unix int64) time.Time {
+	if unix == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(unix, 0).UTC()
+}
+
+// envList parses the variable as a comma separated list.
+func envList(env map[string]string, key string) []string {
+	var list []string
+
+	for _, item := range strings.Split(env[key], ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
@@ -0,0 +1,192 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestEnvironmentContext(t *testing.T) {
+	createMockEnv(t)
+	t.Setenv("VELA_BUILD_STARTED", "1556720970")
+	t.Setenv("VELA_BUILD_FINISHED", "1556721162")
+	t.Setenv("VELA_BUILD_STATUS", "success")
+	t.Setenv("VELA_REPO_LINK", "https://github.com/octocat/hello-world")
+	t.Setenv("VELA_REPO_PRIVATE", "true")
+	t.Setenv("VELA_REPO_TIMEOUT", "30")
+	t.Setenv("VELA_REPO_TOPICS", "go, vela")
+	t.Setenv("VELA_STEP_NAME", "email on success")
+	t.Setenv("VELA_STEP_NUMBER", "not-a-number")
+
+	env := mockPlugin.Environment()
+
+	if env["VELA_BUILD_NUMBER"] != "1" || env["BuildCreated"] != mockBuildEnv.BuildCreated {
+		t.Errorf("Environment() is missing the flat variables: %v", env)
+	}
+
+	build, ok := env["Build"].(*BuildContext)
+	if !ok {
+		t.Fatalf("Environment() Build is %T, want *BuildContext", env["Build"])
+	}
+
+	if build.Number != 1 || build.Status != "success" || build.Branch != "main" {
+		t.Errorf("Build is %+v", build)
+	}
+
+	if !build.Created.Equal(time.Unix(1556720958, 0)) {
+		t.Errorf("Build.Created is %s", build.Created)
+	}
+
+	if build.Duration != 192*time.Second {
+		t.Errorf("Build.Duration is %s, want 3m12s", build.Duration)
+	}
+
+	repo, ok := env["Repo"].(*RepoContext)
+	if !ok {
+		t.Fatalf("Environment() Repo is %T, want *RepoContext", env["Repo"])
+	}
+
+	if repo.FullName != "octocat/hello-world" || repo.Link != "https://github.com/octocat/hello-world" ||
+		!repo.Private || repo.Timeout != 30 || !slices.Equal(repo.Topics, []string{"go", "vela"}) {
+		t.Errorf("Repo is %+v", repo)
+	}
+
+	step, ok := env["Step"].(*StepContext)
+	if !ok {
+		t.Fatalf("Environment() Step is %T, want *StepContext", env["Step"])
+	}
+
+	if step.Name != "email on success" || step.Number != 0 {
+		t.Errorf("Step is %+v", step)
+	}
+}
+
+func TestInjectEnvContext(t *testing.T) {
+	createMockEnv(t)
+	t.Setenv("VELA_BUILD_STARTED", "1556720970")
+	t.Setenv("VELA_BUILD_FINISHED", "1556721162")
+	t.Setenv("VELA_REPO_PRIVATE", "true")
+	t.Setenv("VELA_STEP_NAME", "email")
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{template: "{{ .Build.Number }} of {{ .Repo.FullName }}", want: "1 of octocat/hello-world"},
+		{template: "next build is {{ add .Build.Number 1 }}", want: "next build is 2"},
+		{template: "{{ if gt .Build.Duration.Minutes 3.0 }}slow{{ else }}fast{{ end }}", want: "slow"},
+		{template: "{{ duration .Build.Duration }}", want: "3m 12s"},
+		{template: "{{ .Build.Started.Year }}", want: "2019"},
+		{template: "{{ if .Repo.Private }}private{{ end }} {{ .Step.Name }}", want: "private email"},
+		{template: "{{ .VELA_BUILD_NUMBER }} {{ .Build.Commit | trunc 7 }}", want: "1 7fd1a60"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.template, func(t *testing.T) {
+			got, err := mockPlugin.injectEnv(test.template)
+			if err != nil {
+				t.Errorf("InjectEnv() should not have raised an error %s", err)
+			}
+
+			if got != test.want {
+				t.Errorf("InjectEnv() is %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNewBuildEnv(t *testing.T) {
+	chicago, err := time.LoadLocation("America/Chicago")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		finished int64
+		loc      *time.Location
+		layout   string
+		want     *BuildEnv
+	}{
+		{
+			name:     "default layout in UTC",
+			finished: 1556721170,
+			loc:      time.UTC,
+			layout:   TimeLayout(""),
+			want: &BuildEnv{
+				BuildCreated:   time.Unix(1556720958, 0).UTC().String(),
+				BuildEnqueued:  "2019-05-01 14:29:23 +0000 UTC",
+				BuildStarted:   "2019-05-01 14:29:38 +0000 UTC",
+				BuildFinished:  "2019-05-01 14:32:50 +0000 UTC",
+				BuildQueueTime: "15s",
+				BuildRunTime:   "3m 12s",
+				BuildTotalTime: "3m 32s",
+			},
+		},
+		{
+			name:     "named layout in local time zone",
+			finished: 1556721170,
+			loc:      chicago,
+			layout:   TimeLayout("RFC1123"),
+			want: &BuildEnv{
+				BuildCreated:   "Wed, 01 May 2019 09:29:18 CDT",
+				BuildEnqueued:  "Wed, 01 May 2019 09:29:23 CDT",
+				BuildStarted:   "Wed, 01 May 2019 09:29:38 CDT",
+				BuildFinished:  "Wed, 01 May 2019 09:32:50 CDT",
+				BuildQueueTime: "15s",
+				BuildRunTime:   "3m 12s",
+				BuildTotalTime: "3m 32s",
+			},
+		},
+		{
+			name:   "custom layout while running",
+			loc:    chicago,
+			layout: TimeLayout("Jan 2 3:04PM"),
+			want: &BuildEnv{
+				BuildCreated:   "May 1 9:29AM",
+				BuildEnqueued:  "May 1 9:29AM",
+				BuildStarted:   "May 1 9:29AM",
+				BuildQueueTime: "15s",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := NewBuildEnv(1556720958, 1556720963, 1556720978, test.finished, test.loc, test.layout)
+
+			// the run and total times are measured until now while running
+			if test.finished == 0 {
+				got.BuildRunTime, got.BuildTotalTime = "", ""
+			}
+
+			if *got != *test.want {
+				t.Errorf("NewBuildEnv() is %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEnvironmentContextLocation(t *testing.T) {
+	createMockEnv(t)
+	t.Setenv("VELA_BUILD_ENQUEUED", "1556720963")
+	t.Setenv("VELA_BUILD_STARTED", "1556720978")
+	t.Setenv("VELA_BUILD_FINISHED", "1556721170")
+
+	chicago, err := time.LoadLocation("America/Chicago")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := Plugin{BuildEnv: mockBuildEnv, Location: chicago}
+
+	got, err := p.injectEnv(`{{ .Build.Started | date "15:04 MST" }} {{ duration .Build.QueueTime }} {{ duration .Build.RunTime }} {{ duration .Build.TotalTime }}`)
+	if err != nil {
+		t.Errorf("InjectEnv() should not have raised an error %s", err)
+	}
+
+	if want := "09:29 CDT 15s 3m 12s 3m 32s"; got != want {
+		t.Errorf("InjectEnv() is %q, want %q", got, want)
+	}
+}
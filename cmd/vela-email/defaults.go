@@ -9,7 +9,8 @@ const DefaultSubject = `
 
 // default html body returns the build link and build number,
 // full repository name (org/repo), build author and email,
-// branch, build commit, build start time, and build commit message.
+// branch, build commit, build start time, time spent queued and running,
+// and build commit message.
 const DefaultHTMLBody = `
 <table>
    <tbody>
@@ -46,7 +47,15 @@ const DefaultHTMLBody = `
                                  </tr>
                                  <tr>
                                     <td>Started at:</td>
-                                    <td>{{ .BuildCreated }}</td>
+                                    <td>{{ .BuildStarted }}</td>
+                                 </tr>
+                                 <tr>
+                                    <td>Queued for:</td>
+                                    <td>{{ .BuildQueueTime }}</td>
+                                 </tr>
+                                 <tr>
+                                    <td>Running for:</td>
+                                    <td>{{ .BuildRunTime }}</td>
                                  </tr>
                               </tbody>
                            </table>
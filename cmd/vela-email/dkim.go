@@ -0,0 +1,327 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// dkimHeaders are the headers signed when no header list is provided.
+var dkimHeaders = []string{
+	"From", "Reply-To", "Subject", "Date", "To", "Cc", "Message-Id",
+	"In-Reply-To", "References", "MIME-Version", "Content-Type",
+}
+
+// DKIM represents the configuration for signing emails with DKIM.
+type DKIM struct {
+	// Domain is the signing domain (d=)
+	Domain string
+	// Selector is the selector of the public key record (s=)
+	Selector string
+	// PrivateKey is the RSA or Ed25519 private key file or PEM
+	PrivateKey string
+	// Headers are the headers to sign, the From header is always signed
+	Headers []string
+	// Canonicalization is the header/body canonicalization such as relaxed/relaxed
+	Canonicalization string
+
+	signer crypto.Signer
+}
+
+// headerField represents a header field of a message
+// with its name and raw text, including folding.
+type headerField struct {
+	name string
+	raw  string
+}
+
+// enabled reports whether DKIM signing is configured.
+func (d *DKIM) enabled() bool {
+	return d != nil && (len(d.Domain) > 0 || len(d.Selector) > 0 || len(d.PrivateKey) > 0)
+}
+
+// validate checks the domain, selector, canonicalization
+// and private key are provided and loads the key.
+func (d *DKIM) validate() error {
+	if len(d.Domain) == 0 || len(d.Selector) == 0 || len(d.PrivateKey) == 0 {
+		return fmt.Errorf("%w: dkim_domain, dkim_selector and dkim_private_key are required", ErrorInvalidDKIMParam)
+	}
+
+	if _, _, err := d.canonicalization(); err != nil {
+		return err
+	}
+
+	return d.loadKey()
+}
+
+// canonicalization returns whether the header and body use relaxed
+// canonicalization, with a body defaulting to simple when omitted.
+func (d *DKIM) canonicalization() (bool, bool, error) {
+	c := strings.ToLower(strings.TrimSpace(d.Canonicalization))
+	if len(c) == 0 {
+		return true, true, nil
+	}
+
+	header, body, _ := strings.Cut(c, "/")
+	if len(body) == 0 {
+		body = "simple"
+	}
+
+	valid := map[string]bool{"simple": false, "relaxed": true}
+
+	relaxedHeader, okHeader := valid[header]
+	relaxedBody, okBody := valid[body]
+
+	if !okHeader || !okBody {
+		return false, false, fmt.Errorf("%w: unknown canonicalization %s", ErrorInvalidDKIMParam, d.Canonicalization)
+	}
+
+	return relaxedHeader, relaxedBody, nil
+}
+
+// loadKey parses the RSA or Ed25519 private key.
+func (d *DKIM) loadKey() error {
+	if d.signer != nil {
+		return nil
+	}
+
+	data, err := readPEM(d.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrorInvalidDKIMParam, err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return fmt.Errorf("%w: no private key found", ErrorInvalidDKIMParam)
+	}
+
+	var key any
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrorInvalidDKIMParam, err)
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 1024 {
+			return fmt.Errorf("%w: rsa keys must be at least 1024 bits", ErrorInvalidDKIMParam)
+		}
+
+		d.signer = k
+	case ed25519.PrivateKey:
+		d.signer = k
+	default:
+		return fmt.Errorf("%w: unsupported private key type %T", ErrorInvalidDKIMParam, key)
+	}
+
+	return nil
+}
+
+// sign returns the message with a DKIM-Signature header
+// signing the body and headers at the time.
+func (d *DKIM) sign(msg []byte, t time.Time) ([]byte, error) {
+	logrus.Trace("entered plugin.dkim.sign")
+	defer logrus.Trace("exited plugin.dkim.sign")
+
+	if err := d.loadKey(); err != nil {
+		return nil, err
+	}
+
+	relaxedHeader, relaxedBody, err := d.canonicalization()
+	if err != nil {
+		return nil, err
+	}
+
+	fields, body := splitMessage(msg)
+
+	bodyHash := sha256.Sum256(canonicalBody(body, relaxedBody))
+
+	names := d.Headers
+	if len(names) == 0 {
+		names = dkimHeaders
+	}
+
+	signed, headers := selectHeaders(fields, append([]string{"From"}, names...))
+
+	algorithm := "rsa-sha256"
+	if _, ok := d.signer.(ed25519.PrivateKey); ok {
+		algorithm = "ed25519-sha256"
+	}
+
+	c := map[bool]string{false: "simple", true: "relaxed"}
+
+	value := fmt.Sprintf("v=1; a=%s; c=%s/%s; d=%s; s=%s; t=%d;\r\n\th=%s;\r\n\tbh=%s;\r\n\tb=",
+		algorithm, c[relaxedHeader], c[relaxedBody], d.Domain, d.Selector, t.Unix(),
+		strings.Join(signed, ":"), base64.StdEncoding.EncodeToString(bodyHash[:]))
+
+	h := sha256.New()
+
+	for _, header := range headers {
+		h.Write([]byte(canonicalHeader(header, relaxedHeader)))
+	}
+
+	// the signature header is hashed without its trailing CRLF
+	h.Write([]byte(strings.TrimSuffix(canonicalHeader("DKIM-Signature: "+value+"\r\n", relaxedHeader), "\r\n")))
+
+	var signature []byte
+
+	switch key := d.signer.(type) {
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(key, h.Sum(nil))
+	default:
+		signature, err = d.signer.Sign(rand.Reader, h.Sum(nil), crypto.SHA256)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	logrus.Debugf("Signed email with DKIM for %s with selector %s", d.Domain, d.Selector)
+
+	header := "DKIM-Signature: " + value + base64.StdEncoding.EncodeToString(signature) + "\r\n"
+
+	return append([]byte(header), msg...), nil
+}
+
+// splitMessage returns the header fields and body of the message.
+func splitMessage(msg []byte) ([]headerField, []byte) {
+	head, body, found := bytes.Cut(msg, []byte("\r\n\r\n"))
+	if !found {
+		head, body = bytes.TrimSuffix(msg, []byte("\r\n")), nil
+	}
+
+	var fields []headerField
+
+	for _, line := range strings.SplitAfter(string(head)+"\r\n", "\r\n") {
+		if len(line) == 0 {
+			continue
+		}
+
+		// folded lines continue the previous field
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1].raw += line
+
+			continue
+		}
+
+		name, _, _ := strings.Cut(line, ":")
+
+		fields = append(fields, headerField{name: strings.TrimSpace(name), raw: line})
+	}
+
+	return fields, body
+}
+
+// selectHeaders returns the names and raw fields of the headers to
+// sign, using every instance of a header from the bottom up.
+func selectHeaders(fields []headerField, names []string) ([]string, []string) {
+	var (
+		signed  []string
+		headers []string
+		seen    = map[string]bool{}
+	)
+
+	for _, name := range names {
+		key := strings.ToLower(strings.TrimSpace(name))
+		if len(key) == 0 || seen[key] {
+			continue
+		}
+
+		seen[key] = true
+
+		for i := len(fields) - 1; i >= 0; i-- {
+			if strings.EqualFold(fields[i].name, key) {
+				signed = append(signed, fields[i].name)
+				headers = append(headers, fields[i].raw)
+			}
+		}
+	}
+
+	return signed, headers
+}
+
+// canonicalHeader returns the header field with the simple or
+// relaxed header canonicalization of RFC 6376 section 3.4.
+func canonicalHeader(raw string, relaxed bool) string {
+	if !relaxed {
+		return raw
+	}
+
+	name, value, _ := strings.Cut(raw, ":")
+
+	value = strings.TrimLeft(collapseWSP(strings.ReplaceAll(value, "\r\n", "")), " ")
+
+	return strings.ToLower(strings.TrimRight(name, " \t")) + ":" + value + "\r\n"
+}
+
+// canonicalBody returns the body with the simple or relaxed
+// body canonicalization of RFC 6376 section 3.4.
+func canonicalBody(body []byte, relaxed bool) []byte {
+	lines := strings.Split(string(body), "\r\n")
+
+	if relaxed {
+		for i, line := range lines {
+			lines[i] = collapseWSP(line)
+		}
+	}
+
+	// empty lines at the end of the body are ignored
+	for len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+
+	if len(lines) == 0 {
+		if relaxed {
+			return nil
+		}
+
+		return []byte("\r\n")
+	}
+
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// collapseWSP reduces every sequence of spaces and tabs to a
+// single space and removes them from the end of the text.
+func collapseWSP(s string) string {
+	var b strings.Builder
+
+	wsp := false
+
+	for i := 0; i < len(s); i++ {
+		if s[i] == ' ' || s[i] == '\t' {
+			wsp = true
+
+			continue
+		}
+
+		if wsp {
+			b.WriteByte(' ')
+
+			wsp = false
+		}
+
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
@@ -0,0 +1,378 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jordan-wright/email"
+)
+
+// verifyDKIM verifies the first DKIM-Signature of the message with the public key.
+func verifyDKIM(msg []byte, pub crypto.PublicKey) error {
+	fields, body := splitMessage(msg)
+
+	var signature *headerField
+
+	for i := range fields {
+		if strings.EqualFold(fields[i].name, "DKIM-Signature") {
+			signature = &fields[i]
+
+			break
+		}
+	}
+
+	if signature == nil {
+		return errors.New("no DKIM-Signature header")
+	}
+
+	_, value, _ := strings.Cut(strings.ReplaceAll(signature.raw, "\r\n", ""), ":")
+
+	tags := map[string]string{}
+
+	for _, tag := range strings.Split(value, ";") {
+		name, value, _ := strings.Cut(tag, "=")
+		tags[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	c := tags["c"]
+	if !strings.Contains(c, "/") {
+		c += "/simple"
+	}
+
+	relaxedHeader, relaxedBody := strings.HasPrefix(c, "relaxed/"), strings.HasSuffix(c, "/relaxed")
+
+	bodyHash := sha256.Sum256(canonicalBody(body, relaxedBody))
+	if got := base64.StdEncoding.EncodeToString(bodyHash[:]); got != tags["bh"] {
+		return fmt.Errorf("body hash is %s, want %s", got, tags["bh"])
+	}
+
+	h := sha256.New()
+	used := map[string]int{}
+
+	for _, name := range strings.Split(tags["h"], ":") {
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		// instances are used from the bottom up and missing instances are skipped
+		seen := 0
+
+		for i := len(fields) - 1; i >= 0; i-- {
+			if strings.ToLower(fields[i].name) != name {
+				continue
+			}
+
+			if seen == used[name] {
+				h.Write([]byte(canonicalHeader(fields[i].raw, relaxedHeader)))
+
+				break
+			}
+
+			seen++
+		}
+
+		used[name]++
+	}
+
+	unsigned := regexp.MustCompile(`(^|;)(\s*b\s*=)[^;]*`).ReplaceAllString(signature.raw, "$1$2")
+	h.Write([]byte(strings.TrimSuffix(canonicalHeader(unsigned, relaxedHeader), "\r\n")))
+
+	sig, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(tags["b"]), ""))
+	if err != nil {
+		return err
+	}
+
+	switch key := pub.(type) {
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, h.Sum(nil), sig) {
+			return errors.New("invalid ed25519 signature")
+		}
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, h.Sum(nil), sig)
+	}
+
+	return nil
+}
+
+// dkimKey returns a new private key of the type as PEM with its public key.
+func dkimKey(t *testing.T, keyType string) (string, crypto.PublicKey) {
+	t.Helper()
+
+	var (
+		key   crypto.Signer
+		block *pem.Block
+		err   error
+	)
+
+	if keyType == "ed25519" {
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	} else {
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+
+	if err != nil {
+		t.Fatalf("GenerateKey() should not have raised an error %s", err)
+	}
+
+	if rsaKey, ok := key.(*rsa.PrivateKey); ok && keyType == "rsa-pkcs1" {
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}
+	} else {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatalf("MarshalPKCS8PrivateKey() should not have raised an error %s", err)
+		}
+
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+
+	return string(pem.EncodeToMemory(block)), key.Public()
+}
+
+func TestCanonicalization(t *testing.T) {
+	// the example of RFC 6376 section 3.4.6
+	msg := []byte("A: X\r\nB : Y\t\r\n\tZ  \r\n\r\n C \r\nD \t E\r\n\r\n\r\n")
+
+	tests := []struct {
+		name     string
+		relaxed  bool
+		wantHead string
+		wantBody string
+	}{
+		{name: "relaxed", relaxed: true, wantHead: "a:X\r\nb:Y Z\r\n", wantBody: " C\r\nD E\r\n"},
+		{name: "simple", wantHead: "A: X\r\nB : Y\t\r\n\tZ  \r\n", wantBody: " C \r\nD \t E\r\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fields, body := splitMessage(msg)
+
+			var head string
+			for _, field := range fields {
+				head += canonicalHeader(field.raw, test.relaxed)
+			}
+
+			if head != test.wantHead {
+				t.Errorf("canonicalHeader() is %q, want %q", head, test.wantHead)
+			}
+
+			if got := string(canonicalBody(body, test.relaxed)); got != test.wantBody {
+				t.Errorf("canonicalBody() is %q, want %q", got, test.wantBody)
+			}
+		})
+	}
+}
+
+func TestCanonicalBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		relaxed bool
+		want    string
+	}{
+		{name: "simple empty", body: "", want: "\r\n"},
+		{name: "relaxed empty", body: "", relaxed: true, want: ""},
+		{name: "simple blank lines", body: "\r\n\r\n", want: "\r\n"},
+		{name: "relaxed whitespace only", body: " \t\r\n\r\n", relaxed: true, want: ""},
+		{name: "missing final line break", body: "body", relaxed: true, want: "body\r\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := string(canonicalBody([]byte(test.body), test.relaxed)); got != test.want {
+				t.Errorf("canonicalBody() is %q, want %q", got, test.want)
+			}
+		})
+	}
+
+	// the body hash of the example of RFC 6376 appendix A
+	body := []byte("Hi.\r\n\r\nWe lost the game. Are you hungry yet?\r\n\r\nJoe.\r\n")
+
+	for _, relaxed := range []bool{false, true} {
+		sum := sha256.Sum256(canonicalBody(body, relaxed))
+
+		if got := base64.StdEncoding.EncodeToString(sum[:]); got != "2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=" {
+			t.Errorf("canonicalBody() hash is %s, want 2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=", got)
+		}
+	}
+}
+
+func TestVerifyDKIMVector(t *testing.T) {
+	// the Ed25519 example of RFC 8463 appendix A
+	seed, _ := base64.StdEncoding.DecodeString("nWGxne/9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A=")
+	pub, _ := base64.StdEncoding.DecodeString("11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo=")
+
+	if got := ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey); !bytes.Equal(got, pub) {
+		t.Fatalf("NewKeyFromSeed() public key is %x, want %x", got, pub)
+	}
+
+	msg := strings.ReplaceAll(`DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed;
+ d=football.example.com; i=@football.example.com;
+ q=dns/txt; s=brisbane; t=1528637909; h=from : to :
+ subject : date : message-id : from : subject : date;
+ bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;
+ b=/gCrinpcQOoIfuHNQIbq4pgh9kyIK3AQUdt9OdqQehSwhEIug4D11Bus
+ Fa3bT3FY5OsU7ZbnKELq+eXdp1Q1Dw==
+From: Joe SixPack <joe@football.example.com>
+To: Suzie Q <suzie@shopping.example.net>
+Subject: Is dinner ready?
+Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)
+Message-ID: <20030712040037.46341.5F8J@football.example.com>
+
+Hi.
+
+We lost the game.  Are you hungry yet?
+
+Joe.
+`, "\n", "\r\n")
+
+	if err := verifyDKIM([]byte(msg), ed25519.PublicKey(pub)); err != nil {
+		t.Errorf("verifyDKIM() should not have raised an error %s", err)
+	}
+}
+
+func TestDKIMSign(t *testing.T) {
+	msg := []byte("From: Vela <vela@example.com>\r\nTo: one@example.com\r\nSubject:  Build\r\n\tpassed \r\n" +
+		"Date: Fri, 16 Oct 2026 12:00:00 +0000\r\nX-Unsigned: 1\r\n\r\nbody  \r\n\r\n")
+
+	tests := []struct {
+		keyType          string
+		canonicalization string
+		wantTag          string
+	}{
+		{keyType: "rsa", wantTag: "a=rsa-sha256; c=relaxed/relaxed;"},
+		{keyType: "rsa-pkcs1", canonicalization: "simple/simple", wantTag: "a=rsa-sha256; c=simple/simple;"},
+		{keyType: "ed25519", canonicalization: "relaxed", wantTag: "a=ed25519-sha256; c=relaxed/simple;"},
+		{keyType: "ed25519", canonicalization: "simple/relaxed", wantTag: "a=ed25519-sha256; c=simple/relaxed;"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.keyType+" "+test.canonicalization, func(t *testing.T) {
+			key, pub := dkimKey(t, test.keyType)
+
+			d := &DKIM{Domain: "example.com", Selector: "vela", PrivateKey: key, Canonicalization: test.canonicalization}
+
+			if err := d.validate(); err != nil {
+				t.Errorf("validate() should not have raised an error %s", err)
+			}
+
+			signed, err := d.sign(msg, time.Unix(1792152000, 0))
+			if err != nil {
+				t.Fatalf("sign() should not have raised an error %s", err)
+			}
+
+			if !bytes.HasSuffix(signed, msg) || !strings.Contains(string(signed), test.wantTag) {
+				t.Errorf("sign() is %q, want the message with %q", signed, test.wantTag)
+			}
+
+			if !strings.Contains(string(signed), "t=1792152000;\r\n\th=From:Subject:Date:To;") {
+				t.Errorf("sign() is %q, want only the default headers present in the message signed", signed)
+			}
+
+			if err := verifyDKIM(signed, pub); err != nil {
+				t.Errorf("verifyDKIM() should not have raised an error %s", err)
+			}
+
+			// changing a signed header breaks the signature
+			tampered := bytes.Replace(signed, []byte("one@example.com"), []byte("two@example.com"), 1)
+
+			if err := verifyDKIM(tampered, pub); err == nil {
+				t.Errorf("verifyDKIM() should have raised an error for a tampered message")
+			}
+		})
+	}
+}
+
+func TestExecDKIM(t *testing.T) {
+	key, pub := dkimKey(t, "ed25519")
+
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "dkim.key")
+
+	if err := os.WriteFile(keyFile, []byte(key), 0o600); err != nil {
+		t.Fatalf("WriteFile() should not have raised an error %s", err)
+	}
+
+	p := &Plugin{
+		Email: &email.Email{
+			To:      []string{"fakemail1@example.com"},
+			From:    "fakemail2@example.com",
+			Subject: "subject",
+			Text:    []byte("body"),
+			HTML:    []byte("<p>body</p>"),
+		},
+		DKIM:     &DKIM{Domain: "example.com", Selector: "vela", PrivateKey: keyFile, Headers: []string{"Subject", "To", "Message-Id"}},
+		SendType: "File",
+		FilePath: filepath.Join(dir, "outbox"),
+		BuildEnv: mockBuildEnv,
+	}
+
+	if err := p.Validate(); err != nil {
+		t.Errorf("Validate() should not have raised an error %s", err)
+	}
+
+	if err := p.Exec(); err != nil {
+		t.Errorf("Exec() should not have raised an error %s", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "outbox", "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("Exec() wrote %q, want one message", files)
+	}
+
+	msg, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("ReadFile() should not have raised an error %s", err)
+	}
+
+	if !strings.Contains(string(msg), "h=From:Subject:To:Message-Id;") {
+		t.Errorf("Exec() wrote %q, want the From and listed headers signed", msg)
+	}
+
+	if err := verifyDKIM(msg, pub); err != nil {
+		t.Errorf("verifyDKIM() should not have raised an error %s", err)
+	}
+}
+
+func TestValidateDKIM(t *testing.T) {
+	key, _ := dkimKey(t, "rsa")
+	cert, _ := testCertificate(t)
+	_, ecdsaKey := pemEncode(t, cert)
+
+	tests := []struct {
+		name string
+		dkim *DKIM
+	}{
+		{name: "missing selector", dkim: &DKIM{Domain: "example.com", PrivateKey: key}},
+		{name: "unknown canonicalization", dkim: &DKIM{Domain: "example.com", Selector: "vela", PrivateKey: key, Canonicalization: "nowsp"}},
+		{name: "missing key file", dkim: &DKIM{Domain: "example.com", Selector: "vela", PrivateKey: "testdata/missing.key"}},
+		{name: "unsupported key", dkim: &DKIM{Domain: "example.com", Selector: "vela", PrivateKey: ecdsaKey}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &Plugin{
+				Email:  &email.Email{To: []string{"fakemail1@example.com"}, From: "fakemail2@example.com"},
+				DKIM:   test.dkim,
+				DryRun: true,
+			}
+
+			if err := p.Validate(); !errors.Is(err, ErrorInvalidDKIMParam) {
+				t.Errorf("Validate() error = %v, wantErr = %v", err, ErrorInvalidDKIMParam)
+			}
+		})
+	}
+}
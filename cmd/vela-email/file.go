@@ -0,0 +1,129 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fileFormats are the lower case formats messages can be written in.
+var fileFormats = map[string]bool{
+	"eml":     true,
+	"maildir": true,
+}
+
+// fileDeliveries counts the messages written by the process,
+// keeping Maildir names unique within the same microsecond.
+var fileDeliveries atomic.Uint64
+
+// useFile reports whether the email is written to the
+// file path instead of being sent.
+func (p *Plugin) useFile() bool {
+	return strings.EqualFold(p.SendType, "file")
+}
+
+// validateFile checks the file path and format are provided.
+func (p *Plugin) validateFile() error {
+	if len(p.FilePath) == 0 {
+		return fmt.Errorf("%w: missing file_path", ErrorInvalidFileParam)
+	}
+
+	if len(p.FileFormat) > 0 && !fileFormats[strings.ToLower(p.FileFormat)] {
+		return fmt.Errorf("%w: unknown file_format %s", ErrorInvalidFileParam, p.FileFormat)
+	}
+
+	return nil
+}
+
+// fileSender writes messages to a directory as .eml files
+// or into a Maildir instead of sending them.
+type fileSender struct {
+	dir     string
+	maildir bool
+}
+
+// Send writes the message as it would have been sent, which
+// omits the Bcc header. Maildir messages use LF line endings.
+func (s *fileSender) Send(_ context.Context, msg *Message) error {
+	logrus.Trace("entered plugin.fileSender")
+	defer logrus.Trace("exited plugin.fileSender")
+
+	now := time.Now()
+
+	var path string
+
+	if s.maildir {
+		host, err := os.Hostname()
+		if err != nil || len(host) == 0 {
+			host = "localhost"
+		}
+
+		// Maildir names must not contain a slash and reserve the colon for flags
+		host = strings.NewReplacer("/", `\057`, ":", `\072`).Replace(host)
+		name := fmt.Sprintf("%d.M%dP%dQ%d.%s", now.Unix(), now.Nanosecond()/1000, os.Getpid(), fileDeliveries.Add(1), host)
+
+		for _, sub := range []string{"tmp", "new", "cur"} {
+			if err := os.MkdirAll(filepath.Join(s.dir, sub), 0o755); err != nil {
+				return err
+			}
+		}
+
+		// messages are written to tmp and moved to new once complete
+		tmp := filepath.Join(s.dir, "tmp", name)
+		path = filepath.Join(s.dir, "new", name)
+
+		if err := os.WriteFile(tmp, bytes.ReplaceAll(msg.Raw, []byte("\r\n"), []byte("\n")), 0o644); err != nil { //nolint:gosec // the Maildir is read by a mail client or later step that may run as another user
+			return err
+		}
+
+		if err := os.Rename(tmp, path); err != nil {
+			return err
+		}
+	} else {
+		if err := os.MkdirAll(s.dir, 0o755); err != nil {
+			return err
+		}
+
+		path = filepath.Join(s.dir, now.UTC().Format("20060102T150405.000000000Z")+"-"+messageIDName(msg.Raw)+".eml")
+
+		if err := os.WriteFile(path, msg.Raw, 0o644); err != nil { //nolint:gosec // eml files are opened by later steps or uploaded as artifacts, possibly as another user
+			return err
+		}
+	}
+
+	logrus.Infof("Email written to %s", path)
+
+	return nil
+}
+
+// messageIDName returns the Message-Id of the message
+// with the characters unsafe in file names replaced.
+func messageIDName(raw []byte) string {
+	m, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return "message"
+	}
+
+	id := strings.Trim(m.Header.Get("Message-Id"), "<> ")
+	if len(id) == 0 {
+		return "message"
+	}
+
+	return strings.Map(func(r rune) rune {
+		if 'A' <= r && r <= 'Z' || 'a' <= r && r <= 'z' || '0' <= r && r <= '9' || strings.ContainsRune(".-_@", r) {
+			return r
+		}
+
+		return '_'
+	}, id)
+}
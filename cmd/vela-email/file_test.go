@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jordan-wright/email"
+)
+
+func TestExecFile(t *testing.T) {
+	tests := []struct {
+		format      string
+		pattern     string
+		wantNewline string
+	}{
+		{format: "", pattern: "*.eml", wantNewline: "\r\n"},
+		{format: "eml", pattern: "*.eml", wantNewline: "\r\n"},
+		{format: "Maildir", pattern: "new/*", wantNewline: "\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "outbox")
+
+			p := &Plugin{
+				Email: &email.Email{
+					To:      []string{"fakemail1@example.com"},
+					Bcc:     []string{"hidden@example.com"},
+					From:    "fakemail2@example.com",
+					Subject: "Build {{ .VELA_BUILD_BRANCH }}",
+					Text:    []byte("body"),
+				},
+				SendType:   "File",
+				FilePath:   dir,
+				FileFormat: test.format,
+				BuildEnv:   mockBuildEnv,
+			}
+
+			createMockEnv(t)
+
+			if err := p.Validate(); err != nil {
+				t.Errorf("Validate() should not have raised an error %s", err)
+			}
+
+			// every message is written to its own file
+			for range 2 {
+				if err := p.Exec(); err != nil {
+					t.Errorf("Exec() should not have raised an error %s", err)
+				}
+			}
+
+			files, err := filepath.Glob(filepath.Join(dir, test.pattern))
+			if err != nil || len(files) != 2 {
+				t.Fatalf("Exec() wrote %q, want 2 files matching %s", files, test.pattern)
+			}
+
+			data, err := os.ReadFile(files[0])
+			if err != nil {
+				t.Fatalf("ReadFile() should not have raised an error %s", err)
+			}
+
+			msg := string(data)
+
+			if !strings.Contains(msg, "Subject: Build main"+test.wantNewline) || strings.Contains(msg, "hidden@example.com") {
+				t.Errorf("Exec() wrote %q, want the rendered message without the Bcc header", msg)
+			}
+
+			if test.wantNewline == "\n" && strings.Contains(msg, "\r\n") {
+				t.Errorf("Exec() wrote %q, want LF line endings", msg)
+			}
+
+			if tmp, _ := os.ReadDir(filepath.Join(dir, "tmp")); len(tmp) > 0 {
+				t.Errorf("Exec() left %d messages in tmp", len(tmp))
+			}
+		})
+	}
+}
+
+func TestValidateFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		format  string
+		wantErr error
+	}{
+		{name: "missing path", format: "eml", wantErr: ErrorInvalidFileParam},
+		{name: "unknown format", path: "outbox", format: "mbox", wantErr: ErrorInvalidFileParam},
+		{name: "maildir", path: "outbox", format: "maildir"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &Plugin{
+				Email:      &email.Email{To: []string{"fakemail1@example.com"}, From: "fakemail2@example.com"},
+				SendType:   "file",
+				FilePath:   test.path,
+				FileFormat: test.format,
+			}
+
+			if err := p.Validate(); !errors.Is(err, test.wantErr) {
+				t.Errorf("Validate() error = %v, wantErr = %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -2,7 +2,11 @@
 
 package main
 
-import "github.com/urfave/cli/v3"
+import (
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
 
 // flags returns the CLI flags for the application.
 func flags() []cli.Flag {
@@ -63,29 +67,58 @@ func flags() []cli.Flag {
 			Usage:   "body of message in html format",
 			Sources: cli.EnvVars("PARAMETER_HTML", "EMAIL_HTML"),
 		},
+		&cli.StringFlag{
+			Name:    "text.file",
+			Usage:   "file in the workspace containing the body of message in text format",
+			Sources: cli.EnvVars("PARAMETER_TEXT_FILE", "EMAIL_TEXT_FILE"),
+		},
+		&cli.StringFlag{
+			Name:    "html.file",
+			Usage:   "file in the workspace containing the body of message in html format",
+			Sources: cli.EnvVars("PARAMETER_HTML_FILE", "EMAIL_HTML_FILE"),
+		},
+		&cli.BoolFlag{
+			Name:    "text.from.html",
+			Usage:   "build the text body from the html body when no text is provided",
+			Sources: cli.EnvVars("PARAMETER_TEXT_FROM_HTML", "EMAIL_TEXT_FROM_HTML"),
+		},
 		&cli.StringFlag{
 			Name:    "readreceipt",
 			Usage:   "request read receipts and delivery notifications",
 			Sources: cli.EnvVars("PARAMETER_READRECEIPT", "EMAIL_READRECEIPT"),
 		},
 		// Attachment flag
-		&cli.StringFlag{
+		&cli.StringSliceFlag{
 			Name:    "attachment",
-			Usage:   "file to attach to email",
+			Usage:   "files or glob patterns to attach to email (supports more than one)",
 			Sources: cli.EnvVars("PARAMETER_ATTACHMENT", "EMAIL_ATTACHMENT"),
 		},
+		&cli.StringFlag{
+			Name:    "attachment.max.size",
+			Usage:   "maximum total size of attachments before they are bundled into a zip (e.g. 10MB)",
+			Sources: cli.EnvVars("PARAMETER_ATTACHMENT_MAX_SIZE", "EMAIL_ATTACHMENT_MAX_SIZE"),
+		},
+		// InlineImages flag
+		&cli.StringSliceFlag{
+			Name:    "inline.images",
+			Usage:   "image files embedded in the html body and referenced as cid:<filename>",
+			Sources: cli.EnvVars("PARAMETER_INLINE_IMAGES", "EMAIL_INLINE_IMAGES"),
+		},
 		// SmtpHost flags
+		&cli.StringSliceFlag{
+			Name:    "host",
+			Usage:   "ordered list of smtp hosts formatted as [sendtype://]host[:port]",
+			Sources: cli.EnvVars("PARAMETER_HOST", "EMAIL_HOST"),
+		},
 		&cli.StringFlag{
-			Name:     "host",
-			Usage:    "smtp host",
-			Required: true,
-			Sources:  cli.EnvVars("PARAMETER_HOST", "EMAIL_HOST"),
+			Name:    "port",
+			Usage:   "smtp port used for hosts without one",
+			Sources: cli.EnvVars("PARAMETER_PORT", "EMAIL_PORT"),
 		},
 		&cli.StringFlag{
-			Name:     "port",
-			Usage:    "smtp port",
-			Required: true,
-			Sources:  cli.EnvVars("PARAMETER_PORT", "EMAIL_PORT"),
+			Name:    "helo.name",
+			Usage:   "hostname sent in the EHLO/HELO greeting, defaults to the worker hostname",
+			Sources: cli.EnvVars("PARAMETER_HELO_NAME", "EMAIL_HELO_NAME"),
 		},
 		&cli.StringFlag{
 			Name:  "username",
@@ -107,6 +140,42 @@ func flags() []cli.Flag {
 				cli.File("/vela/secrets/email/username"),
 			),
 		},
+		&cli.StringFlag{
+			Name:  "token",
+			Usage: "oauth bearer token for XOAuth2 and OAuthBearer authentication",
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("PARAMETER_TOKEN"),
+				cli.EnvVar("EMAIL_TOKEN"),
+				cli.File("/vela/parameters/email/token"),
+				cli.File("/vela/secrets/email/token"),
+			),
+		},
+		// OAuth flags
+		&cli.StringFlag{
+			Name:    "oauth.token.url",
+			Usage:   "token endpoint used to request an oauth bearer token with client credentials",
+			Sources: cli.EnvVars("PARAMETER_OAUTH_TOKEN_URL", "EMAIL_OAUTH_TOKEN_URL"),
+		},
+		&cli.StringFlag{
+			Name:    "oauth.client.id",
+			Usage:   "client id used to request an oauth bearer token",
+			Sources: cli.EnvVars("PARAMETER_OAUTH_CLIENT_ID", "EMAIL_OAUTH_CLIENT_ID"),
+		},
+		&cli.StringFlag{
+			Name:  "oauth.client.secret",
+			Usage: "client secret used to request an oauth bearer token",
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("PARAMETER_OAUTH_CLIENT_SECRET"),
+				cli.EnvVar("EMAIL_OAUTH_CLIENT_SECRET"),
+				cli.File("/vela/parameters/email/oauth_client_secret"),
+				cli.File("/vela/secrets/email/oauth_client_secret"),
+			),
+		},
+		&cli.StringSliceFlag{
+			Name:    "oauth.scopes",
+			Usage:   "scopes requested with the oauth bearer token",
+			Sources: cli.EnvVars("PARAMETER_OAUTH_SCOPES", "EMAIL_OAUTH_SCOPES"),
+		},
 		// EmailFilename flag
 		&cli.StringFlag{
 			Name:    "filename",
@@ -119,19 +188,291 @@ func flags() []cli.Flag {
 			Usage:   "skip tls verify",
 			Sources: cli.EnvVars("PARAMETER_SKIPVERIFY", "EMAIL_SKIPVERIFY"),
 		},
+		&cli.StringFlag{
+			Name:  "ca.cert",
+			Usage: "ca certificate file or PEM trusted in addition to the system certificates",
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("PARAMETER_CA_CERT"),
+				cli.EnvVar("EMAIL_CA_CERT"),
+				cli.File("/vela/parameters/email/ca_cert"),
+				cli.File("/vela/secrets/email/ca_cert"),
+			),
+		},
+		&cli.StringFlag{
+			Name:    "tls.min.version",
+			Value:   "1.2",
+			Usage:   "minimum tls version options: (1.0|1.1|1.2|1.3)",
+			Sources: cli.EnvVars("PARAMETER_TLS_MIN_VERSION", "EMAIL_TLS_MIN_VERSION"),
+		},
+		&cli.StringSliceFlag{
+			Name:    "tls.cipher.suites",
+			Usage:   "allowed tls 1.2 cipher suites (e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256)",
+			Sources: cli.EnvVars("PARAMETER_TLS_CIPHER_SUITES", "EMAIL_TLS_CIPHER_SUITES"),
+		},
+		&cli.StringFlag{
+			Name:  "client.cert",
+			Usage: "client certificate file or PEM used for mutual tls",
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("PARAMETER_CLIENT_CERT"),
+				cli.EnvVar("EMAIL_CLIENT_CERT"),
+				cli.File("/vela/parameters/email/client_cert"),
+				cli.File("/vela/secrets/email/client_cert"),
+			),
+		},
+		&cli.StringFlag{
+			Name:  "client.key",
+			Usage: "client private key file or PEM used for mutual tls",
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("PARAMETER_CLIENT_KEY"),
+				cli.EnvVar("EMAIL_CLIENT_KEY"),
+				cli.File("/vela/parameters/email/client_key"),
+				cli.File("/vela/secrets/email/client_key"),
+			),
+		},
 		// SendType flag
 		&cli.StringFlag{
 			Name:    "sendtype",
 			Value:   "StartTLS",
-			Usage:   "send type options: (Plain|StartTLS|TLS) default is set to StartTLS",
+			Usage:   "send type options: (Auto|Plain|StartTLS|TLS|Sendmail|SendGrid|Mailgun|SES|File) default is set to StartTLS",
 			Sources: cli.EnvVars("PARAMETER_SENDTYPE", "EMAIL_SENDTYPE"),
 		},
+		&cli.StringFlag{
+			Name:    "sendmail.path",
+			Value:   "/usr/sbin/sendmail",
+			Usage:   "sendmail compatible binary used with the Sendmail send type",
+			Sources: cli.EnvVars("PARAMETER_SENDMAIL_PATH", "EMAIL_SENDMAIL_PATH"),
+		},
+		&cli.StringFlag{
+			Name:    "file.path",
+			Usage:   "directory the email is written to with the File send type",
+			Sources: cli.EnvVars("PARAMETER_FILE_PATH", "EMAIL_FILE_PATH"),
+		},
+		&cli.StringFlag{
+			Name:    "file.format",
+			Value:   "eml",
+			Usage:   "format the email is written in with the File send type (eml|maildir)",
+			Sources: cli.EnvVars("PARAMETER_FILE_FORMAT", "EMAIL_FILE_FORMAT"),
+		},
+		// API flags
+		&cli.StringFlag{
+			Name:    "api.endpoint",
+			Usage:   "base url of the email provider api, defaults to the provider's public api",
+			Sources: cli.EnvVars("PARAMETER_API_ENDPOINT", "EMAIL_API_ENDPOINT"),
+		},
+		&cli.StringFlag{
+			Name:  "api.key",
+			Usage: "api key used for the SendGrid and Mailgun send types",
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("PARAMETER_API_KEY"),
+				cli.EnvVar("EMAIL_API_KEY"),
+				cli.File("/vela/parameters/email/api_key"),
+				cli.File("/vela/secrets/email/api_key"),
+			),
+		},
+		&cli.StringFlag{
+			Name:    "api.domain",
+			Usage:   "sending domain used for the Mailgun send type",
+			Sources: cli.EnvVars("PARAMETER_API_DOMAIN", "EMAIL_API_DOMAIN"),
+		},
+		&cli.StringFlag{
+			Name:    "aws.region",
+			Usage:   "aws region used for the SES send type",
+			Sources: cli.EnvVars("PARAMETER_AWS_REGION", "EMAIL_AWS_REGION", "AWS_REGION"),
+		},
+		&cli.StringFlag{
+			Name:  "aws.access.key.id",
+			Usage: "aws access key id used for the SES send type",
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("PARAMETER_AWS_ACCESS_KEY_ID"),
+				cli.EnvVar("EMAIL_AWS_ACCESS_KEY_ID"),
+				cli.EnvVar("AWS_ACCESS_KEY_ID"),
+				cli.File("/vela/parameters/email/aws_access_key_id"),
+				cli.File("/vela/secrets/email/aws_access_key_id"),
+			),
+		},
+		&cli.StringFlag{
+			Name:  "aws.secret.access.key",
+			Usage: "aws secret access key used for the SES send type",
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("PARAMETER_AWS_SECRET_ACCESS_KEY"),
+				cli.EnvVar("EMAIL_AWS_SECRET_ACCESS_KEY"),
+				cli.EnvVar("AWS_SECRET_ACCESS_KEY"),
+				cli.File("/vela/parameters/email/aws_secret_access_key"),
+				cli.File("/vela/secrets/email/aws_secret_access_key"),
+			),
+		},
+		&cli.StringFlag{
+			Name:  "aws.session.token",
+			Usage: "aws session token used for the SES send type with temporary credentials",
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("PARAMETER_AWS_SESSION_TOKEN"),
+				cli.EnvVar("EMAIL_AWS_SESSION_TOKEN"),
+				cli.EnvVar("AWS_SESSION_TOKEN"),
+				cli.File("/vela/parameters/email/aws_session_token"),
+				cli.File("/vela/secrets/email/aws_session_token"),
+			),
+		},
+		&cli.BoolFlag{
+			Name:    "require.tls",
+			Usage:   "fail instead of sending without tls when the smtp host does not offer it",
+			Sources: cli.EnvVars("PARAMETER_REQUIRE_TLS", "EMAIL_REQUIRE_TLS"),
+		},
 		// Auth flag
 		&cli.StringFlag{
 			Name:    "auth",
-			Usage:   "authentication for login type (PlainAuth|LoginAuth) default is set to nil",
+			Usage:   "authentication for login type (Auto|PlainAuth|LoginAuth|CRAMMD5|XOAuth2|OAuthBearer) default is set to nil",
 			Sources: cli.EnvVars("PARAMETER_AUTH", "EMAIL_AUTH"),
 		},
+		&cli.BoolFlag{
+			Name:    "allow.insecure.auth",
+			Usage:   "allow sending credentials over an unencrypted connection",
+			Sources: cli.EnvVars("PARAMETER_ALLOW_INSECURE_AUTH", "EMAIL_ALLOW_INSECURE_AUTH"),
+		},
+		// Timeout flags
+		&cli.DurationFlag{
+			Name:    "connect.timeout",
+			Value:   30 * time.Second,
+			Usage:   "time limit for connecting to the smtp host",
+			Sources: cli.EnvVars("PARAMETER_CONNECT_TIMEOUT", "EMAIL_CONNECT_TIMEOUT"),
+		},
+		&cli.DurationFlag{
+			Name:    "command.timeout",
+			Value:   time.Minute,
+			Usage:   "time limit for the tls handshake and each smtp command",
+			Sources: cli.EnvVars("PARAMETER_COMMAND_TIMEOUT", "EMAIL_COMMAND_TIMEOUT"),
+		},
+		&cli.DurationFlag{
+			Name:    "total.timeout",
+			Value:   5 * time.Minute,
+			Usage:   "time limit for each attempt to send the email",
+			Sources: cli.EnvVars("PARAMETER_TOTAL_TIMEOUT", "EMAIL_TOTAL_TIMEOUT"),
+		},
+		// Retry flags
+		&cli.IntFlag{
+			Name:    "retries",
+			Value:   0,
+			Usage:   "number of times to retry sending after a transient failure",
+			Sources: cli.EnvVars("PARAMETER_RETRIES", "EMAIL_RETRIES"),
+		},
+		&cli.DurationFlag{
+			Name:    "retry.backoff",
+			Value:   2 * time.Second,
+			Usage:   "delay before the first retry, doubled after every attempt",
+			Sources: cli.EnvVars("PARAMETER_RETRY_BACKOFF", "EMAIL_RETRY_BACKOFF"),
+		},
+		&cli.DurationFlag{
+			Name:    "retry.max.backoff",
+			Value:   defaultMaxBackoff,
+			Usage:   "maximum delay between retries",
+			Sources: cli.EnvVars("PARAMETER_RETRY_MAX_BACKOFF", "EMAIL_RETRY_MAX_BACKOFF"),
+		},
+		// SMIME flags
+		&cli.StringFlag{
+			Name:  "smime.cert",
+			Usage: "s/mime signing certificate file or PEM, followed by its chain",
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("PARAMETER_SMIME_CERT"),
+				cli.EnvVar("EMAIL_SMIME_CERT"),
+				cli.File("/vela/parameters/email/smime_cert"),
+				cli.File("/vela/secrets/email/smime_cert"),
+			),
+		},
+		&cli.StringFlag{
+			Name:  "smime.key",
+			Usage: "s/mime signing private key file or PEM",
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("PARAMETER_SMIME_KEY"),
+				cli.EnvVar("EMAIL_SMIME_KEY"),
+				cli.File("/vela/parameters/email/smime_key"),
+				cli.File("/vela/secrets/email/smime_key"),
+			),
+		},
+		&cli.StringFlag{
+			Name:    "smime.recipients",
+			Usage:   "directory or bundle of recipient certificates the email is encrypted for with s/mime",
+			Sources: cli.EnvVars("PARAMETER_SMIME_RECIPIENTS", "EMAIL_SMIME_RECIPIENTS"),
+		},
+		// PGP flags
+		&cli.StringFlag{
+			Name:  "pgp.private.key",
+			Usage: "armored openpgp private key file or block used for signing",
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("PARAMETER_PGP_PRIVATE_KEY"),
+				cli.EnvVar("EMAIL_PGP_PRIVATE_KEY"),
+				cli.File("/vela/parameters/email/pgp_private_key"),
+				cli.File("/vela/secrets/email/pgp_private_key"),
+			),
+		},
+		&cli.StringFlag{
+			Name:  "pgp.passphrase",
+			Usage: "passphrase of the openpgp private key",
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("PARAMETER_PGP_PASSPHRASE"),
+				cli.EnvVar("EMAIL_PGP_PASSPHRASE"),
+				cli.File("/vela/parameters/email/pgp_passphrase"),
+				cli.File("/vela/secrets/email/pgp_passphrase"),
+			),
+		},
+		&cli.StringFlag{
+			Name:    "pgp.keyring",
+			Usage:   "armored openpgp public keyring file or block of the recipients the email is encrypted for",
+			Sources: cli.EnvVars("PARAMETER_PGP_KEYRING", "EMAIL_PGP_KEYRING"),
+		},
+		// DKIM flags
+		&cli.StringFlag{
+			Name:    "dkim.domain",
+			Usage:   "domain the email is signed for with dkim",
+			Sources: cli.EnvVars("PARAMETER_DKIM_DOMAIN", "EMAIL_DKIM_DOMAIN"),
+		},
+		&cli.StringFlag{
+			Name:    "dkim.selector",
+			Usage:   "selector of the dkim public key record",
+			Sources: cli.EnvVars("PARAMETER_DKIM_SELECTOR", "EMAIL_DKIM_SELECTOR"),
+		},
+		&cli.StringFlag{
+			Name:  "dkim.private.key",
+			Usage: "rsa or ed25519 private key file or PEM used for dkim signing",
+			Sources: cli.NewValueSourceChain(
+				cli.EnvVar("PARAMETER_DKIM_PRIVATE_KEY"),
+				cli.EnvVar("EMAIL_DKIM_PRIVATE_KEY"),
+				cli.File("/vela/parameters/email/dkim_private_key"),
+				cli.File("/vela/secrets/email/dkim_private_key"),
+			),
+		},
+		&cli.StringSliceFlag{
+			Name:    "dkim.headers",
+			Usage:   "headers signed with dkim, the From header is always signed",
+			Sources: cli.EnvVars("PARAMETER_DKIM_HEADERS", "EMAIL_DKIM_HEADERS"),
+		},
+		&cli.StringFlag{
+			Name:    "dkim.canonicalization",
+			Value:   "relaxed/relaxed",
+			Usage:   "dkim header/body canonicalization (simple|relaxed)",
+			Sources: cli.EnvVars("PARAMETER_DKIM_CANONICALIZATION", "EMAIL_DKIM_CANONICALIZATION"),
+		},
+		// DryRun flags
+		&cli.BoolFlag{
+			Name:    "dry.run",
+			Usage:   "render the email and write it out instead of sending it",
+			Sources: cli.EnvVars("PARAMETER_DRY_RUN", "EMAIL_DRY_RUN"),
+		},
+		&cli.StringFlag{
+			Name:    "dry.run.output",
+			Usage:   "file the rendered email is written to during a dry run, defaults to stdout",
+			Sources: cli.EnvVars("PARAMETER_DRY_RUN_OUTPUT", "EMAIL_DRY_RUN_OUTPUT"),
+		},
+		// Time flags
+		&cli.StringFlag{
+			Name:    "timezone",
+			Value:   "UTC",
+			Usage:   "time zone used for the build times (e.g. America/Chicago)",
+			Sources: cli.EnvVars("PARAMETER_TIMEZONE", "EMAIL_TIMEZONE"),
+		},
+		&cli.StringFlag{
+			Name:    "time.layout",
+			Usage:   "Go layout or name (e.g. RFC1123) used to format the build times",
+			Sources: cli.EnvVars("PARAMETER_TIME_LAYOUT", "EMAIL_TIME_LAYOUT"),
+		},
 		// Build Flags
 		&cli.IntFlag{
 			Name:    "build-created",
@@ -0,0 +1,557 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// templateFuncs returns the functions available to the subject,
+// header, text and HTML templates. The names and argument order
+// follow sprig so the last argument can be piped into the function:
+//
+//	{{ .VELA_BUILD_COMMIT | trunc 7 }}
+func templateFuncs() map[string]any {
+	return map[string]any{
+		// string functions
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+		"title":      title,
+		"trim":       strings.TrimSpace,
+		"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+		"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+		"replace":    func(old, replacement, s string) string { return strings.ReplaceAll(s, old, replacement) },
+		"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+		"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+		"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+		"split":      func(sep, s string) []string { return strings.Split(s, sep) },
+		"join":       join,
+		"repeat":     repeat,
+		"trunc":      trunc,
+		"truncate":   truncate,
+		"toString":   toString,
+
+		// date functions
+		"now":        time.Now,
+		"date":       date,
+		"dateInZone": dateInZone,
+		"toDate":     func(layout, s string) (time.Time, error) { return time.Parse(layout, s) },
+		"unixEpoch":  unixEpoch,
+		"duration":   duration,
+
+		// math functions
+		"toInt": toInt64,
+		"add":   add,
+		"sub":   sub,
+		"mul":   mul,
+		"div":   div,
+		"mod":   mod,
+		"max":   maxInt,
+		"min":   minInt,
+
+		// default functions
+		"default":  defaultValue,
+		"coalesce": coalesce,
+		"empty":    empty,
+
+		// regex functions
+		"regexMatch":      regexMatch,
+		"regexFind":       regexFind,
+		"regexFindAll":    regexFindAll,
+		"regexReplaceAll": regexReplaceAll,
+
+		// encoding functions
+		"toJson":       toJSON,
+		"toPrettyJson": toPrettyJSON,
+		"fromJson":     fromJSON,
+		"b64enc":       func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+		"b64dec":       b64dec,
+	}
+}
+
+// title capitalizes the first letter of every word.
+func title(s string) string {
+	runes := []rune(s)
+
+	for i, r := range runes {
+		if i == 0 || unicode.IsSpace(runes[i-1]) || runes[i-1] == '-' || runes[i-1] == '_' {
+			runes[i] = unicode.ToTitle(r)
+		}
+	}
+
+	return string(runes)
+}
+
+// join concatenates the elements of a list with the separator.
+func join(sep string, list any) string {
+	v := reflect.ValueOf(list)
+
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return toString(list)
+	}
+
+	elems := make([]string, v.Len())
+	for i := range elems {
+		elems[i] = toString(v.Index(i).Interface())
+	}
+
+	return strings.Join(elems, sep)
+}
+
+// maxRepeatSize limits the size of a repeated string so a template
+// cannot exhaust the memory of the plugin.
+const maxRepeatSize = 1 << 20
+
+// repeat returns the string repeated count times.
+func repeat(count int, s string) (string, error) {
+	if count <= 0 || len(s) == 0 {
+		return "", nil
+	}
+
+	if count > maxRepeatSize/len(s) {
+		return "", fmt.Errorf("%w: %d times %d bytes exceeds %d bytes", ErrorRepeatTooLarge, count, len(s), maxRepeatSize)
+	}
+
+	return strings.Repeat(s, count), nil
+}
+
+// trunc shortens the string to length characters. A negative
+// length keeps the last characters of the string instead.
+func trunc(length int, s string) string {
+	runes := []rune(s)
+
+	switch {
+	case length >= 0 && len(runes) > length:
+		return string(runes[:length])
+	case length < 0 && len(runes) > -length:
+		return string(runes[len(runes)+length:])
+	}
+
+	return s
+}
+
+// truncate shortens the string to at most length characters
+// ending with an ellipsis when any characters are removed.
+func truncate(length int, s string) string {
+	const ellipsis = "..."
+
+	if utf8.RuneCountInString(s) <= length {
+		return s
+	}
+
+	if length <= len(ellipsis) {
+		return trunc(length, s)
+	}
+
+	return trunc(length-len(ellipsis), s) + ellipsis
+}
+
+// toString converts the value into a string.
+func toString(v any) string {
+	switch s := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	case time.Duration:
+		return formatDuration(s)
+	case fmt.Stringer:
+		return s.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// toTime converts a time.Time, a unix timestamp or
+// an RFC 3339 formatted string into a time.
+func toTime(v any) (time.Time, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, nil
+	case *time.Time:
+		if t == nil {
+			return time.Time{}, nil
+		}
+
+		return *t, nil
+	case string:
+		if len(t) == 0 {
+			return time.Time{}, nil
+		}
+
+		if unix, err := strconv.ParseInt(t, 10, 64); err == nil {
+			return time.Unix(unix, 0).UTC(), nil
+		}
+
+		return time.Parse(time.RFC3339, t)
+	default:
+		unix, err := toInt64(v)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("unable to convert %v to a time", v)
+		}
+
+		return time.Unix(unix, 0).UTC(), nil
+	}
+}
+
+// date formats the time using the layout.
+func date(layout string, v any) (string, error) {
+	t, err := toTime(v)
+	if err != nil {
+		return "", err
+	}
+
+	return t.Format(layout), nil
+}
+
+// dateInZone formats the time in the named time zone using the layout.
+func dateInZone(layout string, v any, zone string) (string, error) {
+	t, err := toTime(v)
+	if err != nil {
+		return "", err
+	}
+
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return "", err
+	}
+
+	return t.In(loc).Format(layout), nil
+}
+
+// unixEpoch returns the time as seconds since January 1, 1970 UTC.
+func unixEpoch(v any) (int64, error) {
+	t, err := toTime(v)
+	if err != nil {
+		return 0, err
+	}
+
+	return t.Unix(), nil
+}
+
+// duration formats a time.Duration or a number of seconds such as "3m 12s".
+func duration(v any) (string, error) {
+	if d, ok := v.(time.Duration); ok {
+		return formatDuration(d), nil
+	}
+
+	seconds, err := toInt64(v)
+	if err != nil {
+		return "", err
+	}
+
+	return formatDuration(time.Duration(seconds) * time.Second), nil
+}
+
+// formatDuration formats the duration rounded to the second such as "1h 3m 12s".
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+
+	if d == 0 {
+		return "0s"
+	}
+
+	var (
+		sign  string
+		parts []string
+	)
+
+	if d < 0 {
+		sign = "-"
+		d = -d
+	}
+
+	units := []struct {
+		suffix string
+		unit   time.Duration
+	}{
+		{"d", 24 * time.Hour},
+		{"h", time.Hour},
+		{"m", time.Minute},
+		{"s", time.Second},
+	}
+
+	for _, u := range units {
+		if n := d / u.unit; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d%s", n, u.suffix))
+			d -= n * u.unit
+		}
+	}
+
+	return sign + strings.Join(parts, " ")
+}
+
+// toInt64 converts a number, numeric string or boolean into an integer.
+func toInt64(v any) (int64, error) {
+	switch n := v.(type) {
+	case nil:
+		return 0, nil
+	case bool:
+		if n {
+			return 1, nil
+		}
+
+		return 0, nil
+	case string:
+		s := strings.TrimSpace(n)
+		if len(s) == 0 {
+			return 0, nil
+		}
+
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i, nil
+		}
+
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, fmt.Errorf("unable to convert %q to an integer", n)
+		}
+
+		return int64(f), nil
+	}
+
+	r := reflect.ValueOf(v)
+
+	switch r.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return r.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := r.Uint()
+		if u > math.MaxInt64 {
+			return 0, fmt.Errorf("unable to convert %v to an integer", v)
+		}
+
+		return int64(u), nil
+	case reflect.Float32, reflect.Float64:
+		return int64(r.Float()), nil
+	default:
+		return 0, fmt.Errorf("unable to convert %v to an integer", v)
+	}
+}
+
+// toInt64s converts every value into an integer.
+func toInt64s(values ...any) ([]int64, error) {
+	ints := make([]int64, len(values))
+
+	for i, v := range values {
+		n, err := toInt64(v)
+		if err != nil {
+			return nil, err
+		}
+
+		ints[i] = n
+	}
+
+	return ints, nil
+}
+
+// add returns the sum of the values.
+func add(values ...any) (int64, error) {
+	ints, err := toInt64s(values...)
+	if err != nil {
+		return 0, err
+	}
+
+	var sum int64
+	for _, n := range ints {
+		sum += n
+	}
+
+	return sum, nil
+}
+
+// sub returns a minus b.
+func sub(a, b any) (int64, error) {
+	ints, err := toInt64s(a, b)
+	if err != nil {
+		return 0, err
+	}
+
+	return ints[0] - ints[1], nil
+}
+
+// mul returns the product of the values.
+func mul(values ...any) (int64, error) {
+	ints, err := toInt64s(values...)
+	if err != nil {
+		return 0, err
+	}
+
+	product := int64(1)
+	for _, n := range ints {
+		product *= n
+	}
+
+	return product, nil
+}
+
+// div returns a divided by b.
+func div(a, b any) (int64, error) {
+	ints, err := toInt64s(a, b)
+	if err != nil {
+		return 0, err
+	}
+
+	if ints[1] == 0 {
+		return 0, ErrorDivideByZero
+	}
+
+	return ints[0] / ints[1], nil
+}
+
+// mod returns the remainder of a divided by b.
+func mod(a, b any) (int64, error) {
+	ints, err := toInt64s(a, b)
+	if err != nil {
+		return 0, err
+	}
+
+	if ints[1] == 0 {
+		return 0, ErrorDivideByZero
+	}
+
+	return ints[0] % ints[1], nil
+}
+
+// maxInt returns the largest of the values.
+func maxInt(first any, rest ...any) (int64, error) {
+	ints, err := toInt64s(append([]any{first}, rest...)...)
+	if err != nil {
+		return 0, err
+	}
+
+	return slices.Max(ints), nil
+}
+
+// minInt returns the smallest of the values.
+func minInt(first any, rest ...any) (int64, error) {
+	ints, err := toInt64s(append([]any{first}, rest...)...)
+	if err != nil {
+		return 0, err
+	}
+
+	return slices.Min(ints), nil
+}
+
+// defaultValue returns the given value unless it is empty
+// in which case the default value is returned.
+func defaultValue(def any, given ...any) any {
+	if len(given) == 0 || empty(given[0]) {
+		return def
+	}
+
+	return given[0]
+}
+
+// coalesce returns the first value that is not empty.
+func coalesce(values ...any) any {
+	for _, v := range values {
+		if !empty(v) {
+			return v
+		}
+	}
+
+	return nil
+}
+
+// empty reports whether the value is nil or the zero value of its type.
+func empty(v any) bool {
+	if v == nil {
+		return true
+	}
+
+	if t, ok := v.(time.Time); ok {
+		return t.IsZero()
+	}
+
+	r := reflect.ValueOf(v)
+
+	switch r.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return r.Len() == 0
+	case reflect.Pointer, reflect.Interface:
+		return r.IsNil()
+	default:
+		return r.IsZero()
+	}
+}
+
+// regexMatch reports whether the string contains any match of the regular expression.
+func regexMatch(regex, s string) (bool, error) {
+	return regexp.MatchString(regex, s)
+}
+
+// regexFind returns the first match of the regular expression.
+func regexFind(regex, s string) (string, error) {
+	r, err := regexp.Compile(regex)
+	if err != nil {
+		return "", err
+	}
+
+	return r.FindString(s), nil
+}
+
+// regexFindAll returns up to n matches of the regular expression
+// or all of them when n is negative.
+func regexFindAll(regex, s string, n int) ([]string, error) {
+	r, err := regexp.Compile(regex)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.FindAllString(s, n), nil
+}
+
+// regexReplaceAll replaces every match of the regular expression with the
+// replacement which can reference submatches such as ${1}.
+func regexReplaceAll(regex, s, replacement string) (string, error) {
+	r, err := regexp.Compile(regex)
+	if err != nil {
+		return "", err
+	}
+
+	return r.ReplaceAllString(s, replacement), nil
+}
+
+// toJSON encodes the value as JSON.
+func toJSON(v any) (string, error) {
+	b, err := json.Marshal(v)
+
+	return string(b), err
+}
+
+// toPrettyJSON encodes the value as indented JSON.
+func toPrettyJSON(v any) (string, error) {
+	b, err := json.MarshalIndent(v, "", "  ")
+
+	return string(b), err
+}
+
+// fromJSON decodes the JSON string into a value.
+func fromJSON(s string) (any, error) {
+	var v any
+
+	err := json.Unmarshal([]byte(s), &v)
+
+	return v, err
+}
+
+// b64dec decodes the base64 string.
+func b64dec(s string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+
+	return string(b), err
+}
@@ -0,0 +1,154 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"bytes"
+	"errors"
+	htmltemplate "html/template"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestTemplateFuncs(t *testing.T) {
+	data := map[string]any{
+		"Commit":   "7fd1a60b01f91b314f59955a4e4d4e80d8edf11d",
+		"Status":   "success",
+		"Message":  "Merge pull request #6 from octocat/patch-1",
+		"Number":   "41",
+		"Created":  "1556720958",
+		"Started":  time.Unix(1556720958, 0).UTC(),
+		"Duration": 192 * time.Second,
+		"Empty":    "",
+		"List":     []string{"one", "two"},
+		"JSON":     `{"name":"vela","tags":["ci"]}`,
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		// string functions
+		{name: "upper", template: `{{ .Status | upper }}`, want: "SUCCESS"},
+		{name: "lower", template: `{{ "SUCCESS" | lower }}`, want: "success"},
+		{name: "title", template: `{{ "hello vela-email world" | title }}`, want: "Hello Vela-Email World"},
+		{name: "trim", template: `{{ "  main  " | trim }}`, want: "main"},
+		{name: "trimPrefix", template: `{{ "refs/heads/main" | trimPrefix "refs/heads/" }}`, want: "main"},
+		{name: "trimSuffix", template: `{{ "report.xml" | trimSuffix ".xml" }}`, want: "report"},
+		{name: "replace", template: `{{ "octocat/hello-world" | replace "/" " - " }}`, want: "octocat - hello-world"},
+		{name: "contains", template: `{{ .Message | contains "pull request" }}`, want: "true"},
+		{name: "hasPrefix", template: `{{ .Message | hasPrefix "Merge" }}`, want: "true"},
+		{name: "hasSuffix", template: `{{ .Message | hasSuffix "main" }}`, want: "false"},
+		{name: "split", template: `{{ index ("octocat/hello-world" | split "/") 1 }}`, want: "hello-world"},
+		{name: "join", template: `{{ .List | join ", " }}`, want: "one, two"},
+		{name: "repeat", template: `{{ "=" | repeat 5 }} {{ repeat -1 "=" }}`, want: "===== "},
+		{name: "trunc", template: `{{ .Commit | trunc 7 }} {{ .Commit | trunc -4 }}`, want: "7fd1a60 f11d"},
+		{name: "truncate", template: `{{ .Message | truncate 15 }} {{ "short" | truncate 15 }}`, want: "Merge pull r... short"},
+		{name: "toString", template: `{{ toString 42 }}{{ toString .Duration }}`, want: "423m 12s"},
+
+		// date functions
+		{name: "now", template: `{{ gt (now | unixEpoch) 1556720958 }}`, want: "true"},
+		{name: "date", template: `{{ .Created | date "2006-01-02 15:04" }} {{ .Started | date "Jan 2" }}`, want: "2019-05-01 14:29 May 1"},
+		{name: "dateInZone", template: `{{ dateInZone "15:04 MST" .Started "America/Chicago" }}`, want: "09:29 CDT"},
+		{name: "toDate", template: `{{ toDate "2006-01-02" "2019-05-01" | date "Jan 2, 2006" }}`, want: "May 1, 2019"},
+		{name: "unixEpoch", template: `{{ .Started | unixEpoch }}`, want: "1556720958"},
+		{name: "duration", template: `{{ duration .Duration }} {{ duration 3725 }} {{ duration 0 }}`, want: "3m 12s 1h 2m 5s 0s"},
+
+		// math functions
+		{name: "toInt", template: `{{ toInt .Number }}`, want: "41"},
+		{name: "add", template: `{{ add .Number 1 }}`, want: "42"},
+		{name: "sub", template: `{{ sub .Number 1 }}`, want: "40"},
+		{name: "mul", template: `{{ mul .Number 2 }}`, want: "82"},
+		{name: "div", template: `{{ div .Number 2 }}`, want: "20"},
+		{name: "mod", template: `{{ mod .Number 2 }}`, want: "1"},
+		{name: "max", template: `{{ max 3 .Number 7 }}`, want: "41"},
+		{name: "min", template: `{{ min 3 .Number 7 }}`, want: "3"},
+
+		// default functions
+		{name: "default", template: `{{ .Empty | default "n/a" }} {{ .Status | default "n/a" }}`, want: "n/a success"},
+		{name: "coalesce", template: `{{ coalesce .Empty .Missing .Status }}`, want: "success"},
+		{name: "empty", template: `{{ empty .Empty }} {{ empty .Status }}`, want: "true false"},
+
+		// regex functions
+		{name: "regexMatch", template: `{{ regexMatch "#[0-9]+" .Message }}`, want: "true"},
+		{name: "regexFind", template: `{{ regexFind "#[0-9]+" .Message }}`, want: "#6"},
+		{name: "regexFindAll", template: `{{ regexFindAll "[a-z]+" "a1b2c3" -1 | join "" }}`, want: "abc"},
+		{name: "regexReplaceAll", template: `{{ regexReplaceAll "from (\\w+)/.*" .Message "by ${1}" }}`, want: "Merge pull request #6 by octocat"},
+
+		// encoding functions
+		{name: "toJson", template: `{{ .List | toJson }}`, want: `["one","two"]`},
+		{name: "toPrettyJson", template: `{{ .List | toPrettyJson }}`, want: "[\n  \"one\",\n  \"two\"\n]"},
+		{name: "fromJson", template: `{{ (.JSON | fromJson).name }}`, want: "vela"},
+		{name: "b64enc", template: `{{ "vela" | b64enc }}`, want: "dmVsYQ=="},
+		{name: "b64dec", template: `{{ "dmVsYQ==" | b64dec }}`, want: "vela"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tmpl, err := template.New("test").Funcs(templateFuncs()).Parse(test.template)
+			if err != nil {
+				t.Fatalf("Parse() should not have raised an error %s", err)
+			}
+
+			buffer := new(bytes.Buffer)
+
+			if err := tmpl.Execute(buffer, data); err != nil {
+				t.Fatalf("Execute() should not have raised an error %s", err)
+			}
+
+			if got := buffer.String(); got != test.want {
+				t.Errorf("Execute() is %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestTemplateFuncsErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		wantErr  error
+	}{
+		{name: "div by zero", template: `{{ div 1 0 }}`, wantErr: ErrorDivideByZero},
+		{name: "mod by zero", template: `{{ mod 1 0 }}`, wantErr: ErrorDivideByZero},
+		{name: "repeat too large", template: `{{ repeat 1000000000 "x" }}`, wantErr: ErrorRepeatTooLarge},
+		{name: "repeat overflow", template: `{{ repeat 9223372036854775807 "xx" }}`, wantErr: ErrorRepeatTooLarge},
+		{name: "add non numeric", template: `{{ add "one" 1 }}`},
+		{name: "regex invalid", template: `{{ regexFind "(" "vela" }}`},
+		{name: "b64dec invalid", template: `{{ "%%%" | b64dec }}`},
+		{name: "fromJson invalid", template: `{{ "{" | fromJson }}`},
+		{name: "dateInZone unknown zone", template: `{{ dateInZone "15:04" 0 "Mars/Olympus_Mons" }}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tmpl := template.Must(template.New("test").Funcs(templateFuncs()).Parse(test.template))
+
+			err := tmpl.Execute(new(bytes.Buffer), nil)
+			if err == nil {
+				t.Errorf("Execute() should have raised an error")
+			} else if test.wantErr != nil && !errors.Is(err, test.wantErr) {
+				t.Errorf("Execute() error = %v, wantErr = %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestTemplateFuncsHTML(t *testing.T) {
+	tmpl, err := htmltemplate.New("test").Funcs(templateFuncs()).Parse(`<b>{{ . | upper | truncate 12 }}</b>`)
+	if err != nil {
+		t.Fatalf("Parse() should not have raised an error %s", err)
+	}
+
+	buffer := new(bytes.Buffer)
+
+	if err := tmpl.Execute(buffer, `fix <t> & "quotes"`); err != nil {
+		t.Fatalf("Execute() should not have raised an error %s", err)
+	}
+
+	if got := buffer.String(); !strings.HasPrefix(got, "<b>FIX &lt;T&gt; &amp;") {
+		t.Errorf("Execute() is %q, want escaped output", got)
+	}
+}
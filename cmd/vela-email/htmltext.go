@@ -0,0 +1,235 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// htmlWriter accumulates the plain text representation
+// of an HTML document along with any link footnotes.
+type htmlWriter struct {
+	buf   bytes.Buffer
+	links []string
+	pre   int
+}
+
+// htmlToText converts an HTML body into a readable plain text body.
+// Block elements are separated by line breaks, table cells by spaces,
+// list items are prefixed with a dash and links are kept as numbered
+// footnotes listed at the end of the text.
+func htmlToText(s string) (string, error) {
+	doc, err := html.Parse(strings.NewReader(s))
+	if err != nil {
+		return "", err
+	}
+
+	w := new(htmlWriter)
+	w.walk(doc)
+
+	text := strings.TrimSpace(w.buf.String())
+
+	if len(w.links) > 0 {
+		var footnotes strings.Builder
+
+		for i, link := range w.links {
+			fmt.Fprintf(&footnotes, "\n[%d] %s", i+1, link)
+		}
+
+		text += "\n" + footnotes.String()
+	}
+
+	return text + "\n", nil
+}
+
+// walk writes the node and all of its children.
+func (w *htmlWriter) walk(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		w.text(n.Data)
+
+		return
+	case html.ElementNode:
+		// handled below
+	case html.DocumentNode:
+		w.children(n)
+
+		return
+	default:
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.Head, atom.Script, atom.Style, atom.Title, atom.Template:
+		return
+	case atom.Br:
+		w.newline(1)
+	case atom.Hr:
+		w.newline(1)
+		w.write("----------")
+		w.newline(1)
+	case atom.Img:
+		if alt := attr(n, "alt"); len(alt) > 0 {
+			w.text(alt)
+		}
+	case atom.A:
+		w.children(n)
+		w.link(n)
+	case atom.Td, atom.Th:
+		w.space()
+		w.children(n)
+		w.space()
+	case atom.Li:
+		w.newline(1)
+		w.write("- ")
+		w.children(n)
+		w.newline(1)
+	case atom.Pre:
+		w.newline(2)
+		w.pre++
+		w.children(n)
+		w.pre--
+		w.newline(2)
+	case atom.P, atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6, atom.Blockquote, atom.Ul, atom.Ol:
+		w.newline(2)
+		w.children(n)
+		w.newline(2)
+	case atom.Div, atom.Tr, atom.Table, atom.Section, atom.Article, atom.Header, atom.Footer, atom.Dl, atom.Dt, atom.Dd:
+		w.newline(1)
+		w.children(n)
+		w.newline(1)
+	default:
+		w.children(n)
+	}
+}
+
+// children writes every child of the node.
+func (w *htmlWriter) children(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		w.walk(c)
+	}
+}
+
+// link records the href of an anchor as a footnote unless
+// the anchor text already shows the full destination.
+func (w *htmlWriter) link(n *html.Node) {
+	href := strings.TrimSpace(attr(n, "href"))
+	if len(href) == 0 || strings.HasPrefix(href, "#") {
+		return
+	}
+
+	if strings.TrimSpace(textContent(n)) == href {
+		return
+	}
+
+	index := -1
+
+	for i, link := range w.links {
+		if link == href {
+			index = i
+
+			break
+		}
+	}
+
+	if index < 0 {
+		w.links = append(w.links, href)
+		index = len(w.links) - 1
+	}
+
+	w.space()
+	w.write(fmt.Sprintf("[%d]", index+1))
+}
+
+// text writes a text node collapsing any whitespace
+// unless it is inside a preformatted element.
+func (w *htmlWriter) text(s string) {
+	if w.pre > 0 {
+		w.write(s)
+
+		return
+	}
+
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		if len(s) > 0 {
+			w.space()
+		}
+
+		return
+	}
+
+	if strings.TrimLeft(s, " \t\r\n\f") != s {
+		w.space()
+	}
+
+	w.write(strings.Join(fields, " "))
+
+	if strings.TrimRight(s, " \t\r\n\f") != s {
+		w.space()
+	}
+}
+
+// write appends the string as is.
+func (w *htmlWriter) write(s string) {
+	w.buf.WriteString(s)
+}
+
+// space appends a single space unless the text
+// is empty or already ends in whitespace.
+func (w *htmlWriter) space() {
+	b := w.buf.Bytes()
+	if len(b) == 0 || b[len(b)-1] == ' ' || b[len(b)-1] == '\n' {
+		return
+	}
+
+	w.buf.WriteByte(' ')
+}
+
+// newline ensures the text ends with at least n line breaks
+// and removes any trailing spaces on the current line.
+func (w *htmlWriter) newline(n int) {
+	b := bytes.TrimRight(w.buf.Bytes(), " ")
+	w.buf.Truncate(len(b))
+
+	if len(b) == 0 {
+		return
+	}
+
+	trailing := len(b) - len(bytes.TrimRight(b, "\n"))
+
+	for i := trailing; i < n; i++ {
+		w.buf.WriteByte('\n')
+	}
+}
+
+// attr returns the value of the attribute on the node.
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+
+	return ""
+}
+
+// textContent returns the text of the node and all of its children.
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+
+	var s strings.Builder
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		s.WriteString(textContent(c))
+	}
+
+	return s.String()
+}
@@ -0,0 +1,48 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"testing"
+)
+
+func TestHTMLToText(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{
+			name: "paragraphs and whitespace",
+			html: "<h1>Build\n   <b>failed</b></h1><p>Check   the logs.</p>",
+			want: "Build failed\n\nCheck the logs.\n",
+		},
+		{
+			name: "links as footnotes",
+			html: `<p>See <a href="https://vela/1">build</a>, <a href="https://vela/1">again</a> or <a href="https://vela/2">https://vela/2</a>.</p>`,
+			want: "See build [1], again [1] or https://vela/2.\n\n[1] https://vela/1\n",
+		},
+		{
+			name: "tables and lists",
+			html: "<table><tr><td>Repo:</td><td>octocat/hello-world</td></tr><tr><td>Branch:</td><td>main</td></tr></table><ul><li>one</li><li>two</li></ul>",
+			want: "Repo: octocat/hello-world\nBranch: main\n\n- one\n- two\n",
+		},
+		{
+			name: "preformatted text, images and styles",
+			html: "<style>p { color: red; }</style><img src=\"cid:vela.png\" alt=\"Vela\"><pre>line 1\n  line 2</pre><hr>done",
+			want: "Vela\n\nline 1\n  line 2\n\n----------\ndone\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := htmlToText(test.html)
+			if err != nil {
+				t.Errorf("htmlToText() should not have raised an error %s", err)
+			}
+
+			if got != test.want {
+				t.Errorf("htmlToText() is %q, want %q", got, test.want)
+			}
+		})
+	}
+}
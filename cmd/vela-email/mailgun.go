@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+
+	"github.com/sirupsen/logrus"
+)
+
+// mailgun sends messages through the Mailgun MIME messages API,
+// which delivers the composed message to the recipients as is.
+type mailgun struct {
+	api    *API
+	client *http.Client
+}
+
+// Send posts the composed message with its recipients.
+func (m *mailgun) Send(ctx context.Context, msg *Message) error {
+	logrus.Trace("entered plugin.mailgun")
+	defer logrus.Trace("exited plugin.mailgun")
+
+	var body bytes.Buffer
+
+	w := multipart.NewWriter(&body)
+
+	// the recipients include the Bcc addresses omitted from the message
+	for _, to := range msg.Recipients {
+		if err := w.WriteField("to", to); err != nil {
+			return err
+		}
+	}
+
+	part, err := w.CreateFormFile("message", "message.eml")
+	if err != nil {
+		return err
+	}
+
+	if _, err := part.Write(msg.Raw); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	endpoint := m.api.endpoint("https://api.mailgun.net") + "/v3/" + url.PathEscape(m.api.Domain) + "/messages.mime"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, &body)
+	if err != nil {
+		return err
+	}
+
+	req.SetBasicAuth("api", m.api.Key)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+
+	logrus.Infof("Sending email with Mailgun for %s...", m.api.Domain)
+
+	resp, err := do(m.client, "Mailgun", req)
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		ID string `json:"id"`
+	}
+
+	if err := json.Unmarshal(resp, &result); err == nil && len(result.ID) > 0 {
+		logrus.Debugf("Mailgun queued the email as %s", result.ID)
+	}
+
+	logrus.Info("Email accepted by Mailgun")
+
+	return nil
+}
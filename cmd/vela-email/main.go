@@ -10,6 +10,7 @@ import (
 	"net/mail"
 	"os"
 	"time"
+	_ "time/tzdata"
 
 	"github.com/jordan-wright/email"
 	"github.com/sirupsen/logrus"
@@ -19,17 +20,25 @@ import (
 )
 
 func main() {
+	if err := execute(context.Background(), os.Args); err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+// execute prints the version information and runs the CLI application.
+func execute(ctx context.Context, args []string) error {
 	// capture application version information.
 	pluginVersion := version.New()
 
 	// serialize the version information as pretty JSON
 	bytes, err := json.MarshalIndent(pluginVersion, "", "  ")
 	if err != nil {
-		logrus.Fatal(err)
+		return err
 	}
 
-	// output the version information to stdout
-	fmt.Fprintf(os.Stdout, "%s\n", string(bytes))
+	// output the version information to stderr so stdout only
+	// contains the email when it is rendered or previewed
+	fmt.Fprintf(os.Stderr, "%s\n", string(bytes))
 
 	// create new CLI application
 	cmd := &cli.Command{
@@ -45,14 +54,53 @@ func main() {
 		Action:  run,
 		Version: pluginVersion.Semantic(),
 		Flags:   flags(),
+		Commands: []*cli.Command{
+			{
+				Name:   "render",
+				Usage:  "Render the email and write it to stdout or the dry run output file without sending it.",
+				Action: render,
+			},
+		},
 	}
 
-	if err := cmd.Run(context.Background(), os.Args); err != nil {
-		logrus.Fatal(err)
-	}
+	return cmd.Run(ctx, args)
 }
 
 func run(_ context.Context, cmd *cli.Command) error {
+	p, err := setup(cmd)
+	if err != nil {
+		return err
+	}
+
+	// validates the plugin
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	// execute the plugin
+	return p.Exec()
+}
+
+func render(_ context.Context, cmd *cli.Command) error {
+	p, err := setup(cmd)
+	if err != nil {
+		return err
+	}
+
+	// only render the email
+	p.DryRun = true
+
+	// validates the plugin
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	// execute the plugin
+	return p.Exec()
+}
+
+// setup configures the logger and creates the plugin from the flags.
+func setup(cmd *cli.Command) (*Plugin, error) {
 	switch cmd.String("log.level") {
 	case "t", "trace":
 		logrus.SetLevel(logrus.TraceLevel)
@@ -91,12 +139,20 @@ func run(_ context.Context, cmd *cli.Command) error {
 		"registry": "https://hub.docker.com/r/target/vela-email",
 	}).Info("Vela Email Plugin")
 
+	// load the time zone used for the build times
+	loc, err := time.LoadLocation(cmd.String("timezone"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %s: %w", cmd.String("timezone"), err)
+	}
+
 	// create the plugin
 	p := &Plugin{
 		// sendType configuration
-		SendType: cmd.String("sendtype"),
+		SendType:   cmd.String("sendtype"),
+		RequireTLS: cmd.Bool("require.tls"),
 		// auth configuration
-		Auth: cmd.String("auth"),
+		Auth:              cmd.String("auth"),
+		AllowInsecureAuth: cmd.Bool("allow.insecure.auth"),
 
 		// email configuration
 		Email: &email.Email{
@@ -115,39 +171,116 @@ func run(_ context.Context, cmd *cli.Command) error {
 		// email filename configuration
 		EmailFilename: cmd.String("filename"),
 
+		// body template file configuration
+		HTMLFilename: cmd.String("html.file"),
+		TextFilename: cmd.String("text.file"),
+
+		// text generation configuration
+		TextFromHTML: cmd.Bool("text.from.html"),
+
 		// attachment configuration
-		Attachment: &email.Attachment{
-			Filename: cmd.String("attachment"),
-		},
+		Attachments:       cmd.StringSlice("attachment"),
+		AttachmentMaxSize: cmd.String("attachment.max.size"),
+
+		// inline image configuration
+		InlineImages: cmd.StringSlice("inline.images"),
 
 		// smtp configuration
 		SMTPHost: &SMTPHost{
-			Host:     cmd.String("host"),
+			Hosts:    cmd.StringSlice("host"),
 			Port:     cmd.String("port"),
 			Username: cmd.String("username"),
 			Password: cmd.String("password"),
+			Token:    cmd.String("token"),
+		},
+		HeloName:     cmd.String("helo.name"),
+		SendmailPath: cmd.String("sendmail.path"),
+		FilePath:     cmd.String("file.path"),
+		FileFormat:   cmd.String("file.format"),
+
+		// s/mime configuration
+		SMIME: &SMIME{
+			Cert:       cmd.String("smime.cert"),
+			Key:        cmd.String("smime.key"),
+			Recipients: cmd.String("smime.recipients"),
+		},
+
+		// pgp configuration
+		PGP: &PGP{
+			PrivateKey: cmd.String("pgp.private.key"),
+			Passphrase: cmd.String("pgp.passphrase"),
+			Keyring:    cmd.String("pgp.keyring"),
+		},
+
+		// dkim configuration
+		DKIM: &DKIM{
+			Domain:           cmd.String("dkim.domain"),
+			Selector:         cmd.String("dkim.selector"),
+			PrivateKey:       cmd.String("dkim.private.key"),
+			Headers:          cmd.StringSlice("dkim.headers"),
+			Canonicalization: cmd.String("dkim.canonicalization"),
+		},
+
+		// api configuration
+		API: &API{
+			Endpoint:        cmd.String("api.endpoint"),
+			Key:             cmd.String("api.key"),
+			Domain:          cmd.String("api.domain"),
+			Region:          cmd.String("aws.region"),
+			AccessKeyID:     cmd.String("aws.access.key.id"),
+			SecretAccessKey: cmd.String("aws.secret.access.key"),
+			SessionToken:    cmd.String("aws.session.token"),
+		},
+
+		// oauth configuration
+		OAuth: &OAuth{
+			TokenURL:     cmd.String("oauth.token.url"),
+			ClientID:     cmd.String("oauth.client.id"),
+			ClientSecret: cmd.String("oauth.client.secret"),
+			Scopes:       cmd.StringSlice("oauth.scopes"),
 		},
 
 		// tls configuration
 		TLSConfig: &tls.Config{
-			ServerName:         cmd.String("host"),
 			InsecureSkipVerify: cmd.Bool("skipverify"), //nolint:gosec // ignore false positive
 		},
+		CACert:        cmd.String("ca.cert"),
+		TLSMinVersion: cmd.String("tls.min.version"),
+		CipherSuites:  cmd.StringSlice("tls.cipher.suites"),
+		ClientCert:    cmd.String("client.cert"),
+		ClientKey:     cmd.String("client.key"),
 
 		// User Friendly Build configuration
-		BuildEnv: &BuildEnv{
-			BuildCreated:  time.Unix(int64(cmd.Int("build-created")), 0).UTC().String(),
-			BuildEnqueued: time.Unix(int64(cmd.Int("build-enqueued")), 0).UTC().String(),
-			BuildFinished: time.Unix(int64(cmd.Int("build-finished")), 0).UTC().String(),
-			BuildStarted:  time.Unix(int64(cmd.Int("build-started")), 0).UTC().String(),
+		BuildEnv: NewBuildEnv(
+			int64(cmd.Int("build-created")),
+			int64(cmd.Int("build-enqueued")),
+			int64(cmd.Int("build-started")),
+			int64(cmd.Int("build-finished")),
+			loc,
+			TimeLayout(cmd.String("time.layout")),
+		),
+
+		// time zone configuration
+		Location: loc,
+
+		// timeout configuration
+		Timeouts: &Timeouts{
+			Connect: cmd.Duration("connect.timeout"),
+			Command: cmd.Duration("command.timeout"),
+			Total:   cmd.Duration("total.timeout"),
 		},
-	}
 
-	// validates the plugin
-	if err := p.Validate(); err != nil {
-		return err
+		// retry configuration
+		Retry: &Retry{
+			Retries:    cmd.Int("retries"),
+			Backoff:    cmd.Duration("retry.backoff"),
+			MaxBackoff: cmd.Duration("retry.max.backoff"),
+		},
+
+		// dry run configuration
+		DryRun:       cmd.Bool("dry.run"),
+		DryRunOutput: cmd.String("dry.run.output"),
 	}
 
-	// execute the plugin
-	return p.Exec()
+	return p, nil
 }
@@ -0,0 +1,43 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestExecuteRender(t *testing.T) {
+	t.Setenv("PARAMETER_TO", "a@example.com")
+	t.Setenv("PARAMETER_FROM", "b@example.com")
+
+	output := filepath.Join(t.TempDir(), "stdout")
+
+	stdout, err := os.Create(output)
+	if err != nil {
+		t.Fatalf("Create() should not have raised an error %s", err)
+	}
+
+	defer stdout.Close()
+
+	original := os.Stdout
+	os.Stdout = stdout
+
+	defer func() { os.Stdout = original }()
+
+	if err := execute(context.Background(), []string{"vela-email", "render"}); err != nil {
+		t.Fatalf("execute() should not have raised an error %s", err)
+	}
+
+	msg, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("ReadFile() should not have raised an error %s", err)
+	}
+
+	if !regexp.MustCompile(`^[A-Za-z-]+: `).Match(msg) {
+		t.Errorf("execute() wrote %.80q to stdout, want the email starting with a header", msg)
+	}
+}
@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// splitEntity separates the headers describing the MIME entity of
+// the message, such as Content-Type, from the other headers so the
+// entity can be signed or encrypted and wrapped in a new entity.
+func splitEntity(msg []byte) (string, []byte) {
+	fields, body := splitMessage(msg)
+
+	var outer, entity strings.Builder
+
+	for _, field := range fields {
+		if strings.HasPrefix(strings.ToLower(field.name), "content-") {
+			entity.WriteString(field.raw)
+		} else {
+			outer.WriteString(field.raw)
+		}
+	}
+
+	entity.WriteString("\r\n")
+	entity.Write(body)
+
+	return outer.String(), []byte(entity.String())
+}
+
+// newBoundary returns a random multipart boundary.
+func newBoundary() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// multipartEntity returns a multipart entity of the content type with the
+// preamble and parts. The line break before a boundary belongs to the
+// boundary, so each part is kept exactly as is for its signature.
+func multipartEntity(contentType, preamble string, parts ...[]byte) []byte {
+	boundary := newBoundary()
+
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "Content-Type: %s;\r\n boundary=\"%s\"\r\n\r\n", contentType, boundary)
+	b.WriteString(preamble + "\r\n")
+
+	for _, part := range parts {
+		b.WriteString("\r\n--" + boundary + "\r\n")
+		b.Write(part)
+	}
+
+	b.WriteString("\r\n--" + boundary + "--\r\n")
+
+	return b.Bytes()
+}
+
+// crlf returns the text with every line ending in CRLF.
+func crlf(text []byte) []byte {
+	return bytes.ReplaceAll(bytes.ReplaceAll(text, []byte("\r\n"), []byte("\n")), []byte("\n"), []byte("\r\n"))
+}
+
+// base64Lines returns the data base64 encoded in lines of 76 characters.
+func base64Lines(data []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	var b bytes.Buffer
+
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+
+	b.WriteString(encoded + "\r\n")
+
+	return b.Bytes()
+}
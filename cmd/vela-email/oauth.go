@@ -0,0 +1,186 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// XOAUTH2 and OAUTHBEARER are not built into go std lib smtp.
+// This helps with smtp hosts such as Microsoft 365 and Google Workspace
+// that are replacing basic authentication with OAuth 2.0 bearer tokens.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// OAuth represents the client credentials used to
+// request a bearer token from an OAuth 2.0 token endpoint.
+type OAuth struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+}
+
+// xoauth2Auth struct contains the username and bearer token for XOAUTH2 authentication.
+type xoauth2Auth struct {
+	username, token, host string
+}
+
+// XOAuth2Auth returns an Auth that implements the XOAUTH2 authentication
+// mechanism. The token is only sent over TLS or to localhost.
+func XOAuth2Auth(username, token, host string) smtp.Auth {
+	return &xoauth2Auth{username, token, host}
+}
+
+// Start begins an authentication with a server.
+func (a *xoauth2Auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if err := checkServer(server, a.host); err != nil {
+		return "", nil, err
+	}
+
+	resp := "user=" + a.username + "\x01auth=Bearer " + a.token + "\x01\x01"
+
+	return "XOAUTH2", []byte(resp), nil
+}
+
+// Next continues the authentication. A challenge from the server
+// contains the error details and is answered with an empty response
+// so the server completes the exchange with its error reply.
+func (a *xoauth2Auth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		logrus.Debugf("XOAUTH2 authentication failed: %s", fromServer)
+
+		return []byte{}, nil
+	}
+
+	return nil, nil
+}
+
+// oauthBearerAuth struct contains the username and bearer token for OAUTHBEARER authentication.
+type oauthBearerAuth struct {
+	username, token, host, port string
+}
+
+// OAuthBearerAuth returns an Auth that implements the OAUTHBEARER
+// authentication mechanism from RFC 7628. The token is only sent
+// over TLS or to localhost.
+func OAuthBearerAuth(username, token, host, port string) smtp.Auth {
+	return &oauthBearerAuth{username, token, host, port}
+}
+
+// Start begins an authentication with a server.
+func (a *oauthBearerAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if err := checkServer(server, a.host); err != nil {
+		return "", nil, err
+	}
+
+	resp := "n,a=" + a.username + ",\x01host=" + a.host + "\x01port=" + a.port + "\x01auth=Bearer " + a.token + "\x01\x01"
+
+	return "OAUTHBEARER", []byte(resp), nil
+}
+
+// Next continues the authentication. A challenge from the server
+// contains the error details and is answered with the dummy
+// response from RFC 7628 so the server completes the exchange.
+func (a *oauthBearerAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		logrus.Debugf("OAUTHBEARER authentication failed: %s", fromServer)
+
+		return []byte("\x01"), nil
+	}
+
+	return nil, nil
+}
+
+// checkServer ensures a token is only sent to the expected
+// host over TLS, unless the host is localhost.
+func checkServer(server *smtp.ServerInfo, host string) error {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return errors.New("unencrypted connection")
+	}
+
+	if server.Name != host {
+		return errors.New("wrong host name")
+	}
+
+	return nil
+}
+
+// isLocalhost reports whether the name refers to the local machine.
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
+
+// token returns the bearer token from the client credentials
+// grant of the token endpoint described in RFC 6749 section 4.4.
+func (o *OAuth) token(ctx context.Context, client *http.Client) (string, error) {
+	logrus.Trace("entered plugin.token")
+	defer logrus.Trace("exited plugin.token")
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {o.ClientID},
+		"client_secret": {o.ClientSecret},
+	}
+
+	if len(o.Scopes) > 0 {
+		form.Set("scope", strings.Join(o.Scopes, " "))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrorOAuthToken, err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	logrus.Infof("Requesting OAuth token from %s...", o.TokenURL)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrorOAuthToken, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrorOAuthToken, err)
+	}
+
+	var result struct {
+		AccessToken      string `json:"access_token"`
+		TokenType        string `json:"token_type"`
+		ExpiresIn        int    `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+
+	if err := json.Unmarshal(body, &result); err != nil && resp.StatusCode == http.StatusOK {
+		return "", fmt.Errorf("%w: %w", ErrorOAuthToken, err)
+	}
+
+	if resp.StatusCode != http.StatusOK || len(result.AccessToken) == 0 {
+		if len(result.Error) > 0 {
+			return "", fmt.Errorf("%w: %s: %s %s", ErrorOAuthToken, resp.Status, result.Error, result.ErrorDescription)
+		}
+
+		return "", fmt.Errorf("%w: %s", ErrorOAuthToken, resp.Status)
+	}
+
+	if len(result.TokenType) > 0 && !strings.EqualFold(result.TokenType, "bearer") {
+		return "", fmt.Errorf("%w: unsupported token type %s", ErrorOAuthToken, result.TokenType)
+	}
+
+	logrus.Debugf("OAuth token expires in %s", time.Duration(result.ExpiresIn)*time.Second)
+
+	return result.AccessToken, nil
+}
@@ -0,0 +1,253 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jordan-wright/email"
+)
+
+func TestOAuthMechanisms(t *testing.T) {
+	tests := []struct {
+		name      string
+		auth      smtp.Auth
+		server    *smtp.ServerInfo
+		wantProto string
+		wantResp  string
+		wantNext  string
+		wantErr   bool
+	}{
+		{
+			name:      "XOAUTH2",
+			auth:      XOAuth2Auth("vela@example.com", "token", "smtp.example.com"),
+			server:    &smtp.ServerInfo{Name: "smtp.example.com", TLS: true},
+			wantProto: "XOAUTH2",
+			wantResp:  "user=vela@example.com\x01auth=Bearer token\x01\x01",
+			wantNext:  "",
+		},
+		{
+			name:      "OAUTHBEARER",
+			auth:      OAuthBearerAuth("vela@example.com", "token", "smtp.example.com", "587"),
+			server:    &smtp.ServerInfo{Name: "smtp.example.com", TLS: true},
+			wantProto: "OAUTHBEARER",
+			wantResp:  "n,a=vela@example.com,\x01host=smtp.example.com\x01port=587\x01auth=Bearer token\x01\x01",
+			wantNext:  "\x01",
+		},
+		{
+			name:      "XOAUTH2 to localhost without TLS",
+			auth:      XOAuth2Auth("vela@example.com", "token", "127.0.0.1"),
+			server:    &smtp.ServerInfo{Name: "127.0.0.1"},
+			wantProto: "XOAUTH2",
+			wantResp:  "user=vela@example.com\x01auth=Bearer token\x01\x01",
+		},
+		{
+			name:    "XOAUTH2 without TLS",
+			auth:    XOAuth2Auth("vela@example.com", "token", "smtp.example.com"),
+			server:  &smtp.ServerInfo{Name: "smtp.example.com"},
+			wantErr: true,
+		},
+		{
+			name:    "OAUTHBEARER to another host",
+			auth:    OAuthBearerAuth("vela@example.com", "token", "smtp.example.com", "587"),
+			server:  &smtp.ServerInfo{Name: "smtp.attacker.com", TLS: true},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			proto, resp, err := test.auth.Start(test.server)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("Start() should have raised an error")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Errorf("Start() should not have raised an error %s", err)
+			}
+
+			if proto != test.wantProto || string(resp) != test.wantResp {
+				t.Errorf("Start() is %s %q, want %s %q", proto, resp, test.wantProto, test.wantResp)
+			}
+
+			// an error challenge is answered so the server can reply with its error
+			next, err := test.auth.Next([]byte(`{"status":"401"}`), true)
+			if err != nil || string(next) != test.wantNext {
+				t.Errorf("Next() is %q %v, want %q", next, err, test.wantNext)
+			}
+
+			if next, err := test.auth.Next(nil, false); next != nil || err != nil {
+				t.Errorf("Next() is %q %v, want nil", next, err)
+			}
+		})
+	}
+}
+
+func TestOAuthToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    string
+		wantErr string
+	}{
+		{
+			name:   "success",
+			status: http.StatusOK,
+			body:   `{"access_token":"secret-token","token_type":"Bearer","expires_in":3599}`,
+			want:   "secret-token",
+		},
+		{
+			name:    "invalid client",
+			status:  http.StatusUnauthorized,
+			body:    `{"error":"invalid_client","error_description":"client secret expired"}`,
+			wantErr: "401 Unauthorized: invalid_client client secret expired",
+		},
+		{
+			name:    "server error",
+			status:  http.StatusBadGateway,
+			body:    `<html>bad gateway</html>`,
+			wantErr: "502 Bad Gateway",
+		},
+		{
+			name:    "unsupported token type",
+			status:  http.StatusOK,
+			body:    `{"access_token":"secret-token","token_type":"mac"}`,
+			wantErr: "unsupported token type mac",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if err := r.ParseForm(); err != nil {
+					t.Errorf("ParseForm() should not have raised an error %s", err)
+				}
+
+				want := map[string]string{
+					"grant_type":    "client_credentials",
+					"client_id":     "vela",
+					"client_secret": "shh",
+					"scope":         "https://outlook.office365.com/.default offline_access",
+				}
+
+				for key, value := range want {
+					if got := r.PostForm.Get(key); got != value {
+						t.Errorf("token request %s is %q, want %q", key, got, value)
+					}
+				}
+
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(test.status)
+				_, _ = w.Write([]byte(test.body))
+			}))
+			t.Cleanup(server.Close)
+
+			o := &OAuth{
+				TokenURL:     server.URL,
+				ClientID:     "vela",
+				ClientSecret: "shh",
+				Scopes:       []string{"https://outlook.office365.com/.default", "offline_access"},
+			}
+
+			got, err := o.token(context.Background(), server.Client())
+			if len(test.wantErr) > 0 {
+				if !errors.Is(err, ErrorOAuthToken) || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("token() error is %v, want it to contain %q", err, test.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Errorf("token() should not have raised an error %s", err)
+			}
+
+			if got != test.want {
+				t.Errorf("token() is %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestExecOAuth(t *testing.T) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"exchanged-token","token_type":"Bearer","expires_in":3599}`))
+	}))
+	t.Cleanup(tokenServer.Close)
+
+	server := newFakeSMTP(t, func(s *fakeSMTP) { s.extensions = []string{"AUTH XOAUTH2 OAUTHBEARER"} })
+
+	p := &Plugin{
+		Email: &email.Email{
+			To:      []string{"fakemail1@example.com"},
+			From:    "fakemail2@example.com",
+			Subject: "subject",
+			Text:    []byte("body"),
+		},
+		SMTPHost: &SMTPHost{Hosts: []string{"127.0.0.1"}, Port: server.port(), Username: "vela@example.com"},
+		Auth:     "XOAuth2",
+		OAuth:    &OAuth{TokenURL: tokenServer.URL, ClientID: "vela", ClientSecret: "shh"},
+		SendType: "Plain",
+		Timeouts: &Timeouts{Connect: time.Second, Command: time.Second},
+		BuildEnv: mockBuildEnv,
+	}
+
+	if err := p.Validate(); err != nil {
+		t.Errorf("Validate() should not have raised an error %s", err)
+	}
+
+	if err := p.Exec(); err != nil {
+		t.Errorf("Exec() should not have raised an error %s", err)
+	}
+
+	resp := base64.StdEncoding.EncodeToString([]byte("user=vela@example.com\x01auth=Bearer exchanged-token\x01\x01"))
+
+	if commands, _ := server.received(); !slices.Contains(commands, "AUTH XOAUTH2 "+resp) {
+		t.Errorf("Exec() commands are %q, want XOAUTH2 with the exchanged token", commands)
+	}
+}
+
+func TestValidateAuth(t *testing.T) {
+	tests := []struct {
+		name    string
+		auth    string
+		host    *SMTPHost
+		oauth   *OAuth
+		wantErr error
+	}{
+		{name: "no auth", host: &SMTPHost{}},
+		{name: "login with credentials", auth: "LoginAuth", host: &SMTPHost{Username: "vela", Password: "shh"}},
+		{name: "login without password", auth: "LoginAuth", host: &SMTPHost{Username: "vela"}, wantErr: ErrorAuthSpecifiedButCredentialsMissing},
+		{name: "crammd5 without password", auth: "CRAMMD5", host: &SMTPHost{Username: "vela"}, wantErr: ErrorAuthSpecifiedButCredentialsMissing},
+		{name: "auto with password", auth: "Auto", host: &SMTPHost{Username: "vela", Password: "shh"}},
+		{name: "auto without credentials", auth: "Auto", host: &SMTPHost{Username: "vela"}, wantErr: ErrorAuthSpecifiedButCredentialsMissing},
+		{name: "xoauth2 with token", auth: "XOAuth2", host: &SMTPHost{Username: "vela", Token: "token"}},
+		{name: "oauthbearer with client credentials", auth: "OAuthBearer", host: &SMTPHost{Username: "vela"}, oauth: &OAuth{TokenURL: "https://login.example.com/token", ClientID: "vela", ClientSecret: "shh"}},
+		{name: "xoauth2 without token", auth: "XOAuth2", host: &SMTPHost{Username: "vela"}, oauth: &OAuth{}, wantErr: ErrorAuthSpecifiedButCredentialsMissing},
+		{name: "xoauth2 without username", auth: "XOAuth2", host: &SMTPHost{Token: "token"}, wantErr: ErrorAuthSpecifiedButCredentialsMissing},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &Plugin{Auth: test.auth, SMTPHost: test.host, OAuth: test.oauth}
+
+			if err := p.validateAuth(); !errors.Is(err, test.wantErr) {
+				t.Errorf("validateAuth() error = %v, wantErr = %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,335 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/sirupsen/logrus"
+)
+
+// pgpHashes maps the signature hashes to their micalg names.
+var pgpHashes = map[crypto.Hash]string{
+	crypto.SHA224: "pgp-sha224",
+	crypto.SHA256: "pgp-sha256",
+	crypto.SHA384: "pgp-sha384",
+	crypto.SHA512: "pgp-sha512",
+}
+
+// PGP represents the configuration for signing
+// and encrypting emails with OpenPGP/MIME.
+type PGP struct {
+	// PrivateKey is the armored private key file or block used for signing
+	PrivateKey string
+	// Passphrase unlocks the private key when it is protected
+	Passphrase string
+	// Keyring is the armored public keyring file or block used for encryption
+	Keyring string
+
+	signer  *openpgp.Entity
+	keyring openpgp.EntityList
+}
+
+// signing reports whether emails are signed.
+func (p *PGP) signing() bool {
+	return p != nil && len(p.PrivateKey) > 0
+}
+
+// encrypting reports whether emails are encrypted.
+func (p *PGP) encrypting() bool {
+	return p != nil && len(p.Keyring) > 0
+}
+
+// enabled reports whether OpenPGP is configured.
+func (p *PGP) enabled() bool {
+	return p.signing() || p.encrypting()
+}
+
+// validate loads the keys and verifies every recipient
+// has a key when the email is encrypted.
+func (p *PGP) validate(recipients []string) error {
+	if p.signing() {
+		if err := p.loadSigner(); err != nil {
+			return err
+		}
+	}
+
+	if p.encrypting() {
+		if err := p.loadKeyring(); err != nil {
+			return err
+		}
+
+		for _, recipient := range recipients {
+			if p.recipientKey(recipient) == nil {
+				return fmt.Errorf("%w: no valid public key for recipient %s", ErrorInvalidPGPParam, recipient)
+			}
+		}
+	}
+
+	return nil
+}
+
+// loadSigner parses the private key and unlocks it with the passphrase.
+func (p *PGP) loadSigner() error {
+	if p.signer != nil {
+		return nil
+	}
+
+	data, err := readPEM(p.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrorInvalidPGPParam, err)
+	}
+
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrorInvalidPGPParam, err)
+	}
+
+	if len(entities) == 0 {
+		return fmt.Errorf("%w: no key found in pgp_private_key", ErrorInvalidPGPParam)
+	}
+
+	signer := entities[0]
+	if signer.PrivateKey == nil {
+		return fmt.Errorf("%w: pgp_private_key is not a private key", ErrorInvalidPGPParam)
+	}
+
+	if err := signer.DecryptPrivateKeys([]byte(p.Passphrase)); err != nil {
+		return fmt.Errorf("%w: unable to unlock pgp_private_key: %w", ErrorInvalidPGPParam, err)
+	}
+
+	if _, ok := signer.SigningKey(time.Now()); !ok {
+		return fmt.Errorf("%w: pgp_private_key has no valid signing key", ErrorInvalidPGPParam)
+	}
+
+	p.signer = signer
+
+	return nil
+}
+
+// loadKeyring parses the recipient public keys.
+func (p *PGP) loadKeyring() error {
+	if p.keyring != nil {
+		return nil
+	}
+
+	data, err := readPEM(p.Keyring)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrorInvalidPGPParam, err)
+	}
+
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrorInvalidPGPParam, err)
+	}
+
+	if len(keyring) == 0 {
+		return fmt.Errorf("%w: no key found in pgp_keyring", ErrorInvalidPGPParam)
+	}
+
+	p.keyring = keyring
+
+	return nil
+}
+
+// recipientKey returns the key in the keyring with a user id
+// for the address that can currently encrypt.
+func (p *PGP) recipientKey(addr string) *openpgp.Entity {
+	now := time.Now()
+
+	for _, entity := range p.keyring {
+		if entity.Revoked(now) {
+			continue
+		}
+
+		if _, ok := entity.EncryptionKey(now); !ok {
+			continue
+		}
+
+		for _, identity := range entity.Identities {
+			if !identity.Revoked(now) && strings.EqualFold(identity.UserId.Email, addr) {
+				return entity
+			}
+		}
+	}
+
+	return nil
+}
+
+// apply signs and encrypts the MIME entity of the message as
+// described in RFC 3156, keeping the other headers outside of it.
+func (p *PGP) apply(msg []byte, recipients []string) ([]byte, error) {
+	logrus.Trace("entered plugin.pgp.apply")
+	defer logrus.Trace("exited plugin.pgp.apply")
+
+	if err := p.validate(recipients); err != nil {
+		return nil, err
+	}
+
+	outer, entity := splitEntity(msg)
+
+	var err error
+
+	// a signed and encrypted email carries the signature inside the encrypted data
+	if p.encrypting() {
+		entity, err = p.encrypt(entity, recipients)
+	} else {
+		entity, err = p.sign(entity)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(outer), entity...), nil
+}
+
+// sign returns a multipart/signed entity with the
+// entity and its detached armored signature.
+func (p *PGP) sign(entity []byte) ([]byte, error) {
+	var signature bytes.Buffer
+
+	if err := openpgp.DetachSign(&signature, p.signer, bytes.NewReader(entity), p.config()); err != nil {
+		return nil, err
+	}
+
+	pkt, err := packet.Read(bytes.NewReader(signature.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+
+	sig, ok := pkt.(*packet.Signature)
+	if !ok {
+		return nil, fmt.Errorf("unexpected pgp signature packet %T", pkt)
+	}
+
+	micalg, ok := pgpHashes[sig.Hash]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported signature hash %s", ErrorInvalidPGPParam, sig.Hash)
+	}
+
+	armored, err := armorBlock(openpgp.SignatureType, signature.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	part := append([]byte("Content-Type: application/pgp-signature; name=\"signature.asc\"\r\n"+
+		"Content-Description: OpenPGP digital signature\r\n"+
+		"Content-Disposition: attachment; filename=\"signature.asc\"\r\n\r\n"), armored...)
+
+	logrus.Debugf("Signed email with OpenPGP key %X", p.signer.PrimaryKey.Fingerprint)
+
+	return multipartEntity(
+		fmt.Sprintf(`multipart/signed; protocol="application/pgp-signature"; micalg=%s`, micalg),
+		"This is an OpenPGP/MIME signed message (RFC 4880 and 3156)",
+		entity, part,
+	), nil
+}
+
+// encrypt returns a multipart/encrypted entity with the entity encrypted
+// for the recipients and the sender, and signed when configured.
+func (p *PGP) encrypt(entity []byte, recipients []string) ([]byte, error) {
+	var to []*openpgp.Entity
+
+	for _, recipient := range recipients {
+		key := p.recipientKey(recipient)
+		if key == nil {
+			return nil, fmt.Errorf("%w: no valid public key for recipient %s", ErrorInvalidPGPParam, recipient)
+		}
+
+		if !containsEntity(to, key) {
+			to = append(to, key)
+		}
+	}
+
+	// the signing key also receives the email when it has an encryption subkey
+	if p.signing() {
+		if _, ok := p.signer.EncryptionKey(time.Now()); ok && !containsEntity(to, p.signer) {
+			to = append(to, p.signer)
+		}
+	}
+
+	var encrypted bytes.Buffer
+
+	w, err := openpgp.Encrypt(&encrypted, to, p.signer, nil, p.config())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrorInvalidPGPParam, err)
+	}
+
+	if _, err := w.Write(entity); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	armored, err := armorBlock("PGP MESSAGE", encrypted.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	control := []byte("Content-Type: application/pgp-encrypted\r\n" +
+		"Content-Description: PGP/MIME version identification\r\n\r\nVersion: 1\r\n")
+
+	part := append([]byte("Content-Type: application/octet-stream; name=\"encrypted.asc\"\r\n"+
+		"Content-Description: OpenPGP encrypted message\r\n"+
+		"Content-Disposition: inline; filename=\"encrypted.asc\"\r\n\r\n"), armored...)
+
+	logrus.Debugf("Encrypted email with OpenPGP for %d keys", len(to))
+
+	return multipartEntity(
+		`multipart/encrypted; protocol="application/pgp-encrypted"`,
+		"This is an OpenPGP/MIME encrypted message (RFC 4880 and 3156)",
+		control, part,
+	), nil
+}
+
+// config returns the OpenPGP configuration for signing and encrypting.
+func (p *PGP) config() *packet.Config {
+	return &packet.Config{
+		DefaultHash:   crypto.SHA256,
+		DefaultCipher: packet.CipherAES256,
+	}
+}
+
+// containsEntity reports whether the entity is in the list.
+func containsEntity(list []*openpgp.Entity, entity *openpgp.Entity) bool {
+	for _, e := range list {
+		if e.PrimaryKey.KeyId == entity.PrimaryKey.KeyId {
+			return true
+		}
+	}
+
+	return false
+}
+
+// armorBlock returns the data armored as the block type with CRLF line endings.
+func armorBlock(blockType string, data []byte) ([]byte, error) {
+	var b bytes.Buffer
+
+	w, err := armor.Encode(&b, blockType, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	b.WriteString("\n")
+
+	return crlf(b.Bytes()), nil
+}
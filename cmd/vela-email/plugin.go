@@ -4,13 +4,18 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
-	"html/template"
+	htmltemplate "html/template"
 	"net/smtp"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/aymerick/douceur/inliner"
 	"github.com/jordan-wright/email"
@@ -27,9 +32,99 @@ var (
 	// ErrorEmptyFile is returned when the plugin finds the provided attachment to be empty.
 	ErrorEmptyFile = errors.New("file provided is empty")
 
+	// ErrorNotRegularFile is returned when the plugin finds the provided attachment to be a directory or other non-regular file.
+	ErrorNotRegularFile = errors.New("file provided is not a regular file")
+
+	// ErrorConflictingBodyParams is returned when a body is provided both inline and from a file.
+	ErrorConflictingBodyParams = errors.New("body provided both inline and from a file")
+
+	// ErrorAttachmentNoMatch is returned when an attachment glob pattern does not match any files.
+	ErrorAttachmentNoMatch = errors.New("attachment pattern matched no files")
+
+	// ErrorAttachmentsTooLarge is returned when the attachments exceed the maximum size even after compression.
+	ErrorAttachmentsTooLarge = errors.New("attachments exceed maximum size")
+
+	// ErrorInlineImagesWithoutHTML is returned when inline images are provided for an email without an HTML body.
+	ErrorInlineImagesWithoutHTML = errors.New("inline images require an HTML body")
+
+	// ErrorDuplicateInlineImage is returned when two inline images share the same Content-ID.
+	ErrorDuplicateInlineImage = errors.New("inline images must have unique filenames")
+
+	// ErrorDivideByZero is returned when a template divides by zero.
+	ErrorDivideByZero = errors.New("integer divide by zero")
+
+	// ErrorRepeatTooLarge is returned when a template repeats a string beyond the maximum size.
+	ErrorRepeatTooLarge = errors.New("repeated string is too large")
+
+	// ErrorInvalidSize is returned when the plugin is provided a size it cannot parse.
+	ErrorInvalidSize = errors.New("invalid size")
+
 	// ErrorMissingSMTPParam is returned when the plugin is missing a smtp host or port parameter.
 	ErrorMissingSMTPParam = errors.New("missing smtp parameter (host/port)")
 
+	// ErrorInvalidRetryParam is returned when the plugin is provided a negative retry count or backoff.
+	ErrorInvalidRetryParam = errors.New("retry parameters must not be negative")
+
+	// ErrorInvalidTimeoutParam is returned when the plugin is provided a negative timeout.
+	ErrorInvalidTimeoutParam = errors.New("timeout parameters must not be negative")
+
+	// ErrorInvalidSMTPHost is returned when the plugin is provided a smtp host it cannot parse.
+	ErrorInvalidSMTPHost = errors.New("invalid smtp host")
+
+	// ErrorOAuthToken is returned when the plugin is unable to obtain a token from the OAuth token endpoint.
+	ErrorOAuthToken = errors.New("unable to obtain oauth token")
+
+	// ErrorAuthUnsupported is returned when credentials are provided but the SMTP server does not offer authentication.
+	ErrorAuthUnsupported = errors.New("smtp server does not support authentication")
+
+	// ErrorTLSRequired is returned when TLS is required but a relay would be used without it.
+	ErrorTLSRequired = errors.New("tls is required")
+
+	// ErrorInvalidHeloName is returned when the plugin is provided a helo name that is not a hostname.
+	ErrorInvalidHeloName = errors.New("invalid helo name")
+
+	// ErrorSendmail is returned when the sendmail binary is missing or fails to send the email.
+	ErrorSendmail = errors.New("sendmail failed")
+
+	// ErrorInvalidFileParam is returned when the plugin is missing or provided an invalid file path or format.
+	ErrorInvalidFileParam = errors.New("invalid file parameter")
+
+	// ErrorMissingProviderParam is returned when the plugin is missing or provided invalid email provider parameters.
+	ErrorMissingProviderParam = errors.New("missing email provider parameter")
+
+	// ErrorProviderAuth is returned when the email provider rejects the credentials.
+	ErrorProviderAuth = errors.New("email provider rejected the credentials")
+
+	// ErrorProviderRejected is returned when the email provider rejects the email.
+	ErrorProviderRejected = errors.New("email provider rejected the email")
+
+	// ErrorProviderUnavailable is returned when the email provider is rate limiting or failing.
+	ErrorProviderUnavailable = errors.New("email provider unavailable")
+
+	// ErrorInvalidDKIMParam is returned when the plugin is provided incomplete or invalid DKIM parameters.
+	ErrorInvalidDKIMParam = errors.New("invalid dkim parameter")
+
+	// ErrorInvalidSMIMEParam is returned when the plugin is provided invalid S/MIME parameters or cannot sign or encrypt the email.
+	ErrorInvalidSMIMEParam = errors.New("invalid s/mime parameter")
+
+	// ErrorInvalidPGPParam is returned when the plugin is provided invalid OpenPGP parameters or a recipient has no public key.
+	ErrorInvalidPGPParam = errors.New("invalid pgp parameter")
+
+	// ErrorInvalidCACert is returned when the plugin is unable to load the CA certificates.
+	ErrorInvalidCACert = errors.New("invalid ca certificate")
+
+	// ErrorInvalidTLSParam is returned when the plugin is provided an unknown tls version or cipher suite.
+	ErrorInvalidTLSParam = errors.New("invalid tls parameter")
+
+	// ErrorInvalidClientCert is returned when the plugin is unable to load the client certificate and key.
+	ErrorInvalidClientCert = errors.New("invalid client certificate")
+
+	// ErrorNoAuthMechanism is returned when the server does not offer an authentication mechanism the plugin can use.
+	ErrorNoAuthMechanism = errors.New("no supported authentication mechanism")
+
+	// ErrorInsecureAuth is returned when credentials would be sent over an unencrypted connection.
+	ErrorInsecureAuth = errors.New("refusing to send credentials over an unencrypted connection")
+
 	// ErrorAuthSpecifiedButCredentialsMissing is returned when the plugin is missing credentials when auth type was specified.
 	ErrorAuthSpecifiedButCredentialsMissing = errors.New("missing credentials when auth type was specified")
 )
@@ -41,34 +136,92 @@ type (
 		Email *email.Email
 		// EmailFilename arguments loaded for the plugin
 		EmailFilename string
-		// Attachment arguments loaded for the plugin
-		Attachment *email.Attachment
+		// HTMLFilename arguments loaded for the plugin
+		HTMLFilename string
+		// TextFilename arguments loaded for the plugin
+		TextFilename string
+		// TextFromHTML arguments loaded for the plugin
+		TextFromHTML bool
+		// Attachments arguments loaded for the plugin (paths or glob patterns)
+		Attachments []string
+		// InlineImages arguments loaded for the plugin (paths or glob patterns)
+		InlineImages []string
+		// AttachmentMaxSize arguments loaded for the plugin (e.g. 10MB)
+		AttachmentMaxSize string
 		// SmtpHost arguments loaded for the plugin
 		SMTPHost *SMTPHost
+		// SendmailPath arguments loaded for the plugin
+		SendmailPath string
+		// FilePath arguments loaded for the plugin
+		FilePath string
+		// FileFormat arguments loaded for the plugin (eml or maildir)
+		FileFormat string
+		// SMIME arguments loaded for the plugin
+		SMIME *SMIME
+		// PGP arguments loaded for the plugin
+		PGP *PGP
+		// DKIM arguments loaded for the plugin
+		DKIM *DKIM
+		// API arguments loaded for the plugin
+		API *API
+		// HeloName arguments loaded for the plugin
+		HeloName string
 		// TLSConfig arguments loaded for the plugin
 		TLSConfig *tls.Config
+		// CACert arguments loaded for the plugin (file or PEM)
+		CACert string
+		// TLSMinVersion arguments loaded for the plugin (e.g. 1.2)
+		TLSMinVersion string
+		// CipherSuites arguments loaded for the plugin
+		CipherSuites []string
+		// ClientCert arguments loaded for the plugin (file or PEM)
+		ClientCert string
+		// ClientKey arguments loaded for the plugin (file or PEM)
+		ClientKey string
 		// SendType arguments loaded for the plugin
 		SendType string
+		// RequireTLS arguments loaded for the plugin
+		RequireTLS bool
 		// Auth arguments loaded for the plugin
 		Auth string
 		// Readable build time environment variables
 		BuildEnv *BuildEnv
+		// Location used for the build times available to templates
+		Location *time.Location
+		// AllowInsecureAuth arguments loaded for the plugin
+		AllowInsecureAuth bool
+		// OAuth arguments loaded for the plugin
+		OAuth *OAuth
+		// Timeouts arguments loaded for the plugin
+		Timeouts *Timeouts
+		// Retry arguments loaded for the plugin
+		Retry *Retry
+		// DryRun arguments loaded for the plugin
+		DryRun bool
+		// DryRunOutput arguments loaded for the plugin
+		DryRunOutput string
 	}
 
 	// SMTPHost struct.
 	SMTPHost struct {
-		Host     string
+		// Hosts is the ordered list of relays formatted as [sendtype://]host[:port]
+		Hosts    []string
 		Port     string
 		Username string
 		Password string
+		// Token is the OAuth 2.0 bearer token used for XOAUTH2 and OAUTHBEARER
+		Token string
 	}
 
 	// User friendly readable Build Environment Variables.
 	BuildEnv struct {
-		BuildCreated  string
-		BuildEnqueued string
-		BuildFinished string
-		BuildStarted  string
+		BuildCreated   string
+		BuildEnqueued  string
+		BuildFinished  string
+		BuildStarted   string
+		BuildQueueTime string
+		BuildRunTime   string
+		BuildTotalTime string
 	}
 )
 
@@ -114,6 +267,32 @@ func (p *Plugin) Validate() error {
 		}
 	}
 
+	if len(p.HTMLFilename) != 0 {
+		if len(p.Email.HTML) != 0 {
+			return fmt.Errorf("%w: html and html_file", ErrorConflictingBodyParams)
+		}
+
+		body, err := readTemplateFile(p.HTMLFilename)
+		if err != nil {
+			return err
+		}
+
+		p.Email.HTML = body
+	}
+
+	if len(p.TextFilename) != 0 {
+		if len(p.Email.Text) != 0 {
+			return fmt.Errorf("%w: text and text_file", ErrorConflictingBodyParams)
+		}
+
+		body, err := readTemplateFile(p.TextFilename)
+		if err != nil {
+			return err
+		}
+
+		p.Email.Text = body
+	}
+
 	if len(p.Email.To) == 0 {
 		return ErrorMissingEmailToParam
 	}
@@ -122,28 +301,96 @@ func (p *Plugin) Validate() error {
 		return ErrorMissingEmailFromParam
 	}
 
-	if len(p.Attachment.Filename) != 0 {
-		fileInfo, err := os.Stat(p.Attachment.Filename)
-		if errors.Is(err, os.ErrNotExist) {
-			return os.ErrNotExist
+	if len(p.Attachments) > 0 {
+		files, err := expandAttachments(p.Attachments)
+		if err != nil {
+			return err
 		}
 
-		if fileInfo.Size() == 0 {
-			return ErrorEmptyFile
+		if err := p.attach(files); err != nil {
+			return err
 		}
+	}
 
-		p.Attachment, err = p.Email.AttachFile(p.Attachment.Filename)
-		if err != nil {
+	// the delivery configuration is not used when only rendering the email
+	if !p.DryRun {
+		provider, useProvider := providers[strings.ToLower(p.SendType)]
+
+		switch {
+		case p.useSendmail():
+			if _, err := exec.LookPath(p.SendmailPath); err != nil {
+				return fmt.Errorf("%w: %w", ErrorSendmail, err)
+			}
+		case p.useFile():
+			if err := p.validateFile(); err != nil {
+				return err
+			}
+		case useProvider:
+			if err := p.API.validate(provider); err != nil {
+				return err
+			}
+
+			if err := p.configureTLS(); err != nil {
+				return err
+			}
+		default:
+			if err := p.validateSMTP(); err != nil {
+				return err
+			}
+		}
+
+		if p.Timeouts != nil && (p.Timeouts.Connect < 0 || p.Timeouts.Command < 0 || p.Timeouts.Total < 0) {
+			return ErrorInvalidTimeoutParam
+		}
+
+		if p.Retry != nil && (p.Retry.Retries < 0 || p.Retry.Backoff < 0 || p.Retry.MaxBackoff < 0) {
+			return ErrorInvalidRetryParam
+		}
+	}
+
+	if err := p.validateBcc(); err != nil {
+		return err
+	}
+
+	if p.PGP.enabled() {
+		if p.SMIME.enabled() {
+			return fmt.Errorf("%w: OpenPGP and S/MIME cannot be used together", ErrorInvalidPGPParam)
+		}
+
+		if strings.EqualFold(p.SendType, "sendgrid") {
+			return fmt.Errorf("%w: the SendGrid send type cannot send OpenPGP emails", ErrorInvalidPGPParam)
+		}
+
+		var recipients []string
+
+		if p.PGP.encrypting() {
+			_, to, err := envelope(p.Email)
+			if err != nil {
+				return err
+			}
+
+			recipients = to
+		}
+
+		if err := p.PGP.validate(recipients); err != nil {
 			return err
 		}
 	}
 
-	if len(p.SMTPHost.Host) == 0 || len(p.SMTPHost.Port) == 0 {
-		return ErrorMissingSMTPParam
+	if p.SMIME.enabled() {
+		if strings.EqualFold(p.SendType, "sendgrid") {
+			return fmt.Errorf("%w: the SendGrid send type cannot send S/MIME emails", ErrorInvalidSMIMEParam)
+		}
+
+		if err := p.SMIME.validate(); err != nil {
+			return err
+		}
 	}
 
-	if len(p.Auth) > 0 && !(len(p.SMTPHost.Username) > 0 && len(p.SMTPHost.Password) > 0) {
-		return ErrorAuthSpecifiedButCredentialsMissing
+	if p.DKIM.enabled() {
+		if err := p.DKIM.validate(); err != nil {
+			return err
+		}
 	}
 
 	// set defaults
@@ -155,31 +402,61 @@ func (p *Plugin) Validate() error {
 		p.Email.HTML = []byte(DefaultHTMLBody)
 	}
 
+	if len(p.InlineImages) > 0 {
+		if len(p.Email.HTML) == 0 {
+			return ErrorInlineImagesWithoutHTML
+		}
+
+		files, err := expandAttachments(p.InlineImages)
+		if err != nil {
+			return err
+		}
+
+		if err := p.embed(files); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
 // Creates an environment map for the plugin to use and adds
 // any environment variables in the os environment as well as
-// some user friendly build timestamps.
-func (p *Plugin) Environment() map[string]string {
+// some user friendly build timestamps. The build, repository
+// and step information is also parsed into typed values that
+// are available as .Build, .Repo and .Step.
+func (p *Plugin) Environment() map[string]any {
 	logrus.Trace("entered plugin.Environment")
 	defer logrus.Trace("exited plugin.Environment")
 
 	logrus.Info("Setting up Environment...")
 
-	envMap := map[string]string{}
+	vars := map[string]string{}
 
 	for _, v := range os.Environ() {
 		splitV := strings.Split(v, "=")
 		if strings.HasPrefix(splitV[0], "VELA_") {
-			envMap[splitV[0]] = strings.Join(splitV[1:], "=")
+			vars[splitV[0]] = strings.Join(splitV[1:], "=")
 		}
 	}
 
+	envMap := map[string]any{}
+
+	for key, value := range vars {
+		envMap[key] = value
+	}
+
 	envMap["BuildCreated"] = p.BuildEnv.BuildCreated
 	envMap["BuildEnqueued"] = p.BuildEnv.BuildEnqueued
 	envMap["BuildFinished"] = p.BuildEnv.BuildFinished
 	envMap["BuildStarted"] = p.BuildEnv.BuildStarted
+	envMap["BuildQueueTime"] = p.BuildEnv.BuildQueueTime
+	envMap["BuildRunTime"] = p.BuildEnv.BuildRunTime
+	envMap["BuildTotalTime"] = p.BuildEnv.BuildTotalTime
+
+	envMap["Build"] = newBuildContext(vars, p.Location)
+	envMap["Repo"] = newRepoContext(vars)
+	envMap["Step"] = newStepContext(vars, p.Location)
 
 	return envMap
 }
@@ -191,105 +468,406 @@ func (p *Plugin) Exec() error {
 	logrus.Trace("entered plugin.Execute")
 	defer logrus.Trace("exited plugin.Execute")
 
-	logrus.Debug("Parsing Subject...")
+	if err := p.render(); err != nil {
+		return err
+	}
 
-	subject, err := p.injectEnv(p.Email.Subject)
+	if p.DryRun {
+		return p.writeMessage()
+	}
+
+	sender, err := p.sender()
 	if err != nil {
 		return err
 	}
 
-	p.Email.Subject = subject
+	msg, err := p.message()
+	if err != nil {
+		return err
+	}
 
-	if len(p.Email.HTML) > 0 {
-		logrus.Debug("Parsing HTML...")
+	if err := sender.Send(context.Background(), msg); err != nil {
+		return err
+	}
 
-		body, err := p.injectEnv(string(p.Email.HTML))
-		if err != nil {
-			return err
+	logrus.Info("Plugin finished")
+
+	return nil
+}
+
+// Validates the relays, helo name, credentials and TLS
+// configuration used to send the email over SMTP.
+func (p *Plugin) validateSMTP() error {
+	relays, err := p.SMTPHost.relays(p.SendType)
+	if err != nil {
+		return err
+	}
+
+	for _, relay := range relays {
+		if p.RequireTLS && relay.SendType == "Plain" {
+			return fmt.Errorf("%w: %s uses the Plain send type", ErrorTLSRequired, relay)
 		}
+	}
 
-		logrus.Debug("Parsing CSS...")
+	if strings.ContainsAny(p.HeloName, " \t\r\n") {
+		return fmt.Errorf("%w: %q", ErrorInvalidHeloName, p.HeloName)
+	}
 
-		body, err = inliner.Inline(body)
-		if err != nil {
-			return err
+	if err := p.validateAuth(); err != nil {
+		return err
+	}
+
+	return p.configureTLS()
+}
+
+// Validates the credentials required by the authentication type.
+func (p *Plugin) validateAuth() error {
+	switch strings.ToLower(p.Auth) {
+	case "":
+		return nil
+	case "auto":
+		if len(p.SMTPHost.Username) == 0 {
+			return ErrorAuthSpecifiedButCredentialsMissing
 		}
 
-		p.Email.HTML = []byte(body)
-	} else {
-		logrus.Debug("Parsing Text...")
+		if len(p.SMTPHost.Password) > 0 || len(p.SMTPHost.Token) > 0 || (p.OAuth != nil && len(p.OAuth.TokenURL) > 0) {
+			return nil
+		}
 
-		body, err := p.injectEnv(string(p.Email.Text))
-		if err != nil {
-			return err
+		return ErrorAuthSpecifiedButCredentialsMissing
+	case "xoauth2", "oauthbearer":
+		if len(p.SMTPHost.Username) == 0 {
+			return ErrorAuthSpecifiedButCredentialsMissing
 		}
 
-		p.Email.Text = []byte(body)
+		if len(p.SMTPHost.Token) > 0 {
+			return nil
+		}
+
+		if p.OAuth == nil || len(p.OAuth.TokenURL) == 0 || len(p.OAuth.ClientID) == 0 || len(p.OAuth.ClientSecret) == 0 {
+			return ErrorAuthSpecifiedButCredentialsMissing
+		}
+
+		return nil
+	default:
+		if len(p.SMTPHost.Username) == 0 || len(p.SMTPHost.Password) == 0 {
+			return ErrorAuthSpecifiedButCredentialsMissing
+		}
+
+		return nil
+	}
+}
+
+// Requests a bearer token from the token endpoint when
+// OAuth authentication is used without a token.
+func (p *Plugin) fetchToken() error {
+	switch strings.ToLower(p.Auth) {
+	case "xoauth2", "oauthbearer", "auto":
+	default:
+		return nil
 	}
 
+	if len(p.SMTPHost.Token) > 0 || p.OAuth == nil || len(p.OAuth.TokenURL) == 0 {
+		return nil
+	}
+
+	token, err := p.OAuth.token(context.Background(), p.httpClient())
+	if err != nil {
+		return err
+	}
+
+	p.SMTPHost.Token = token
+
+	return nil
+}
+
+// Rejects Bcc recipients when the email is encrypted with S/MIME or
+// OpenPGP, as the encrypted email identifies everyone it is encrypted for.
+func (p *Plugin) validateBcc() error {
+	if len(p.Email.Bcc) == 0 {
+		return nil
+	}
+
+	var err error
+
+	switch {
+	case p.SMIME.encrypting():
+		err = ErrorInvalidSMIMEParam
+	case p.PGP.encrypting():
+		err = ErrorInvalidPGPParam
+	default:
+		return nil
+	}
+
+	return fmt.Errorf("%w: bcc recipients would be revealed by encrypting the email", err)
+}
+
+// Returns the authentication for the relay.
+func (p *Plugin) smtpAuth(relay Relay) smtp.Auth {
 	var auth smtp.Auth
 
 	switch strings.ToLower(p.Auth) {
+	case "auto":
+		logrus.Debug("Using automatic authentication negotiation...")
+
+		auth = AutoAuth(p.SMTPHost.Username, p.SMTPHost.Password, p.SMTPHost.Token, relay)
 	case "plainauth":
-		logrus.Info("Using login authentication from smtp/PlainAuth...")
+		logrus.Debug("Using login authentication from smtp/PlainAuth...")
 
-		auth = smtp.PlainAuth("", p.SMTPHost.Username, p.SMTPHost.Password, p.SMTPHost.Host)
+		auth = smtp.PlainAuth("", p.SMTPHost.Username, p.SMTPHost.Password, relay.Host)
 	case "loginauth":
-		logrus.Info("Using login authentication from loginauth/LoginAuth...")
+		logrus.Debug("Using login authentication from loginauth/LoginAuth...")
 
 		auth = LoginAuth(p.SMTPHost.Username, p.SMTPHost.Password)
+	case "crammd5":
+		logrus.Debug("Using login authentication from smtp/CRAMMD5Auth...")
+
+		auth = smtp.CRAMMD5Auth(p.SMTPHost.Username, p.SMTPHost.Password)
+	case "xoauth2":
+		logrus.Debug("Using OAuth authentication from oauth/XOAuth2Auth...")
+
+		auth = XOAuth2Auth(p.SMTPHost.Username, p.SMTPHost.Token, relay.Host)
+	case "oauthbearer":
+		logrus.Debug("Using OAuth authentication from oauth/OAuthBearerAuth...")
+
+		auth = OAuthBearerAuth(p.SMTPHost.Username, p.SMTPHost.Token, relay.Host, relay.Port)
 	default:
-		logrus.Info("Using no login authentication...")
+		logrus.Debug("Using no login authentication...")
+
+		return nil
+	}
 
-		auth = nil
+	return SecureAuth(auth, p.AllowInsecureAuth)
+}
+
+// Sends the message to the relay with its send type.
+func (p *Plugin) send(ctx context.Context, relay Relay, msg *Message) error {
+	logrus.Trace("entered plugin.send")
+	defer logrus.Trace("exited plugin.send")
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if p.TLSConfig != nil {
+		tlsConfig = p.TLSConfig.Clone()
+	}
+
+	tlsConfig.ServerName = relay.Host
+
+	sendType := relay.SendType
+
+	d := &delivery{
+		addr:       relay.String(),
+		helo:       p.heloName(),
+		auth:       p.smtpAuth(relay),
+		tlsConfig:  tlsConfig,
+		requireTLS: p.RequireTLS,
+	}
+
+	if sendType == "Auto" {
+		// implicit TLS is expected on the submissions port while other
+		// ports use STARTTLS when offered, which credentials require
+		if relay.Port == "465" {
+			sendType = "TLS"
+		} else {
+			sendType = "StartTLS"
+			d.requireTLS = d.requireTLS || (d.auth != nil && !p.AllowInsecureAuth)
+		}
+
+		logrus.Debugf("Detected send type %s for %s", sendType, relay)
+	}
+
+	d.startTLS = sendType == "StartTLS"
+	d.implicit = sendType == "TLS"
+
+	if p.Timeouts != nil {
+		d.timeouts = *p.Timeouts
 	}
 
-	host := p.SMTPHost.Host + ":" + p.SMTPHost.Port
+	logrus.Infof("Sending email to %s with %s...", relay, sendType)
+
+	if err := d.send(ctx, msg.From, msg.Recipients, msg.Raw); err != nil {
+		return fmt.Errorf("error sending with %s: %w", sendType, err)
+	}
+
+	return nil
+}
+
+// Returns the name sent in the EHLO/HELO greeting, defaulting to
+// the host of the Vela worker running the build and then to the
+// hostname of the container.
+func (p *Plugin) heloName() string {
+	if len(p.HeloName) > 0 {
+		return p.HeloName
+	}
+
+	if host := os.Getenv("VELA_BUILD_HOST"); len(host) > 0 {
+		return host
+	}
+
+	if host, err := os.Hostname(); err == nil && len(host) > 0 {
+		return host
+	}
+
+	return "localhost"
+}
+
+// Writes the composed email to the dry run output file, or to
+// stdout when no file is provided, instead of sending it.
+func (p *Plugin) writeMessage() error {
+	logrus.Trace("entered plugin.writeMessage")
+	defer logrus.Trace("exited plugin.writeMessage")
+
+	msg, err := p.compose()
+	if err != nil {
+		return err
+	}
+
+	if len(p.DryRunOutput) == 0 || p.DryRunOutput == "-" {
+		logrus.Info("Dry run enabled, writing email to stdout...")
+
+		_, err = os.Stdout.Write(msg)
+
+		return err
+	}
 
-	switch strings.ToLower(p.SendType) {
-	case "starttls":
-		logrus.Info("Sending email with StartTLS...")
+	logrus.Infof("Dry run enabled, writing email to %s...", p.DryRunOutput)
 
-		if err := p.Email.SendWithStartTLS(host, auth, p.TLSConfig); err != nil {
-			return fmt.Errorf("error sending with StartTLS: %w", err)
+	if dir := filepath.Dir(p.DryRunOutput); len(dir) > 0 {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
 		}
-	case "tls":
-		logrus.Info("Sending email with TLS...")
+	}
+
+	return os.WriteFile(p.DryRunOutput, msg, 0o644) //nolint:gosec // rendered previews are meant to be shared as build artifacts
+}
+
+// Parses the subject, text and HTML of the email to inject
+// environment variables. When only HTML is provided and the
+// plugin is configured to, a text part is built from the HTML.
+func (p *Plugin) render() error {
+	logrus.Trace("entered plugin.render")
+	defer logrus.Trace("exited plugin.render")
+
+	logrus.Debug("Parsing Subject...")
+
+	subject, err := p.injectEnv(p.Email.Subject)
+	if err != nil {
+		return err
+	}
+
+	p.Email.Subject = subject
+
+	for key, values := range p.Email.Headers {
+		logrus.Debugf("Parsing Header %s...", key)
+
+		for i, value := range values {
+			header, err := p.injectEnv(value)
+			if err != nil {
+				return err
+			}
 
-		if err := p.Email.SendWithTLS(host, auth, p.TLSConfig); err != nil {
-			return fmt.Errorf("error sending with TLS: %w", err)
+			values[i] = header
 		}
-	case "plain":
-		fallthrough
-	default:
-		logrus.Info("Sending email with Plain...")
+	}
 
-		if err := p.Email.Send(host, auth); err != nil {
-			return fmt.Errorf("error sending with Plain: %w", err)
+	if len(p.Email.Text) > 0 {
+		logrus.Debug("Parsing Text...")
+
+		body, err := p.injectEnv(string(p.Email.Text))
+		if err != nil {
+			return err
 		}
+
+		p.Email.Text = []byte(body)
 	}
 
-	logrus.Info("Plugin finished")
+	if len(p.Email.HTML) > 0 {
+		logrus.Debug("Parsing HTML...")
+
+		body, err := p.injectEnvHTML(string(p.Email.HTML))
+		if err != nil {
+			return err
+		}
+
+		if len(p.Email.Text) == 0 && p.TextFromHTML {
+			logrus.Debug("Generating Text from HTML...")
+
+			text, err := htmlToText(body)
+			if err != nil {
+				return err
+			}
+
+			p.Email.Text = []byte(text)
+		}
+
+		logrus.Debug("Parsing CSS...")
+
+		body, err = inliner.Inline(body)
+		if err != nil {
+			return err
+		}
+
+		p.Email.HTML = []byte(body)
+	}
 
 	return nil
 }
 
-// Injects environment variables into email template.
+// Injects environment variables into a plain text email template
+// such as the subject, headers or text body. No escaping is applied.
 func (p *Plugin) injectEnv(str string) (string, error) {
 	logrus.Trace("entered plugin.InjectEnv")
 	defer logrus.Trace("exited plugin.InjectEnv")
 
-	// Inject to subject
 	buffer := new(bytes.Buffer)
 
 	// parse string to template
-	t := template.Must(template.New("input").Parse(str))
+	t, err := template.New("input").Funcs(templateFuncs()).Parse(str)
+	if err != nil {
+		return "", err
+	}
 
-	err := t.Execute(buffer, p.Environment())
+	err = t.Execute(buffer, p.Environment())
 
 	return buffer.String(), err
 }
 
+// Injects environment variables into an HTML email template.
+// Values are escaped according to the HTML context they appear in.
+func (p *Plugin) injectEnvHTML(str string) (string, error) {
+	logrus.Trace("entered plugin.InjectEnvHTML")
+	defer logrus.Trace("exited plugin.InjectEnvHTML")
+
+	buffer := new(bytes.Buffer)
+
+	// parse string to template
+	t, err := htmltemplate.New("input").Funcs(templateFuncs()).Parse(str)
+	if err != nil {
+		return "", err
+	}
+
+	err = t.Execute(buffer, p.Environment())
+
+	return buffer.String(), err
+}
+
+// reads a template file used for the body of the email.
+func readTemplateFile(filename string) ([]byte, error) {
+	fileInfo, err := os.Stat(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("template %s: %w", filename, os.ErrNotExist)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if fileInfo.Size() == 0 {
+		return nil, fmt.Errorf("template %s: %w", filename, ErrorEmptyFile)
+	}
+
+	return os.ReadFile(filename)
+}
+
 // splits a string of emails and returns them as a slice.
 func stringToSlice(s []string) []string {
 	var slice []string
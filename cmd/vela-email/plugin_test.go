@@ -2,9 +2,14 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
 	"errors"
 	"io"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -19,16 +24,12 @@ var (
 	}
 
 	mockSMTPHost = &SMTPHost{
-		Host:     "smtphost.com",
+		Hosts:    []string{"smtphost.com"},
 		Port:     "587",
 		Username: "username",
 		Password: "password",
 	}
 
-	noAttachment = &email.Attachment{
-		Filename: "",
-	}
-
 	mockBuildEnv = &BuildEnv{
 		BuildCreated:  time.Unix(int64(1556720958), 0).UTC().String(),
 		BuildEnqueued: time.Unix(int64(1556720958), 0).UTC().String(),
@@ -40,7 +41,6 @@ var (
 		Email:         mockEmail,
 		EmailFilename: "",
 		SMTPHost:      mockSMTPHost,
-		Attachment:    noAttachment,
 		BuildEnv:      mockBuildEnv,
 	}
 )
@@ -71,7 +71,6 @@ func TestValidateSuccess(t *testing.T) {
 				Email:         mockEmail,
 				EmailFilename: "",
 				SMTPHost:      mockSMTPHost,
-				Attachment:    noAttachment,
 			},
 		},
 		{
@@ -80,7 +79,6 @@ func TestValidateSuccess(t *testing.T) {
 				Email:         mockEmail,
 				EmailFilename: "",
 				SMTPHost:      mockSMTPHost,
-				Attachment:    noAttachment,
 				Auth:          "LoginAuth",
 			},
 		},
@@ -90,10 +88,9 @@ func TestValidateSuccess(t *testing.T) {
 				Email:         mockEmail,
 				EmailFilename: "",
 				SMTPHost: &SMTPHost{
-					Host: "smtphost.com",
-					Port: "234234",
+					Hosts: []string{"smtphost.com"},
+					Port:  "234234",
 				},
-				Attachment: noAttachment,
 			},
 		},
 		{
@@ -105,7 +102,6 @@ func TestValidateSuccess(t *testing.T) {
 				},
 				EmailFilename: "",
 				SMTPHost:      mockSMTPHost,
-				Attachment:    noAttachment,
 			},
 		},
 		{
@@ -117,10 +113,9 @@ func TestValidateSuccess(t *testing.T) {
 				},
 				EmailFilename: "",
 				SMTPHost: &SMTPHost{
-					Host: "smtphost.com",
-					Port: "587",
+					Hosts: []string{"smtphost.com"},
+					Port:  "587",
 				},
-				Attachment: noAttachment,
 			},
 		},
 		{
@@ -140,7 +135,6 @@ func TestValidateSuccess(t *testing.T) {
 				},
 				EmailFilename: "",
 				SMTPHost:      mockSMTPHost,
-				Attachment:    noAttachment,
 			},
 		},
 		{
@@ -152,9 +146,6 @@ func TestValidateSuccess(t *testing.T) {
 				},
 				EmailFilename: "testdata/example1.txt",
 				SMTPHost:      mockSMTPHost,
-				Attachment: &email.Attachment{
-					Filename: "",
-				},
 			},
 		},
 		{
@@ -163,9 +154,7 @@ func TestValidateSuccess(t *testing.T) {
 				Email:         mockEmail,
 				EmailFilename: "",
 				SMTPHost:      mockSMTPHost,
-				Attachment: &email.Attachment{
-					Filename: "",
-				},
+				Attachments:   []string{"testdata/example1.txt"},
 			},
 		},
 		{
@@ -174,9 +163,28 @@ func TestValidateSuccess(t *testing.T) {
 				Email:         mockEmail,
 				EmailFilename: "",
 				SMTPHost:      mockSMTPHost,
-				Attachment: &email.Attachment{
-					Filename: "testdata/vela.png",
+				Attachments:   []string{"testdata/vela.png"},
+			},
+		},
+		{
+			name: "return no errors: html and text bodies from template files",
+			parameters: Plugin{
+				Email: &email.Email{
+					To:   []string{"fakemail1@example.com"},
+					From: "fakemail2@example.com",
 				},
+				SMTPHost:     mockSMTPHost,
+				HTMLFilename: "testdata/templates/build.html",
+				TextFilename: "testdata/templates/build.txt",
+			},
+		},
+		{
+			name: "return no errors: add multiple attachments and glob patterns to email",
+			parameters: Plugin{
+				Email:         mockEmail,
+				EmailFilename: "",
+				SMTPHost:      mockSMTPHost,
+				Attachments:   []string{"testdata/vela.png", "testdata/reports/**/*.xml", "testdata/reports/*.log"},
 			},
 		},
 	}
@@ -203,7 +211,6 @@ func TestValidateErrors(t *testing.T) {
 					From: "fakemail@example.com",
 				},
 				EmailFilename: "",
-				Attachment:    noAttachment,
 			},
 			wantErr: ErrorMissingEmailToParam,
 		},
@@ -214,7 +221,6 @@ func TestValidateErrors(t *testing.T) {
 					To: []string{"fakemail@example.com"},
 				},
 				EmailFilename: "",
-				Attachment:    noAttachment,
 			},
 			wantErr: ErrorMissingEmailFromParam,
 		},
@@ -227,9 +233,6 @@ func TestValidateErrors(t *testing.T) {
 				},
 				EmailFilename: "testdata/badattachment.txt",
 				SMTPHost:      mockSMTPHost,
-				Attachment: &email.Attachment{
-					Filename: "",
-				},
 			},
 			wantErr: io.EOF,
 		},
@@ -237,9 +240,6 @@ func TestValidateErrors(t *testing.T) {
 			name: "Email file missing",
 			parameters: Plugin{
 				EmailFilename: "testdata/doesnotexist.txt",
-				Attachment: &email.Attachment{
-					Filename: "",
-				},
 			},
 			wantErr: os.ErrNotExist,
 		},
@@ -247,9 +247,6 @@ func TestValidateErrors(t *testing.T) {
 			name: "Email file empty",
 			parameters: Plugin{
 				EmailFilename: "testdata/empty.txt",
-				Attachment: &email.Attachment{
-					Filename: "",
-				},
 			},
 			wantErr: ErrorEmptyFile,
 		},
@@ -258,9 +255,7 @@ func TestValidateErrors(t *testing.T) {
 			parameters: Plugin{
 				Email:         mockEmail,
 				EmailFilename: "",
-				Attachment: &email.Attachment{
-					Filename: "testdata/doesnotexist.txt",
-				},
+				Attachments:   []string{"testdata/doesnotexist.txt"},
 			},
 			wantErr: os.ErrNotExist,
 		},
@@ -269,12 +264,96 @@ func TestValidateErrors(t *testing.T) {
 			parameters: Plugin{
 				Email:         mockEmail,
 				EmailFilename: "",
-				Attachment: &email.Attachment{
-					Filename: "testdata/empty.txt",
-				},
+				Attachments:   []string{"testdata/empty.txt"},
+			},
+			wantErr: ErrorEmptyFile,
+		},
+		{
+			name: "Email attachment directory",
+			parameters: Plugin{
+				Email:         mockEmail,
+				EmailFilename: "",
+				Attachments:   []string{"testdata/reports"},
+			},
+			wantErr: ErrorNotRegularFile,
+		},
+		{
+			name: "Email attachments too large after bundling",
+			parameters: Plugin{
+				Email:             mockEmail,
+				EmailFilename:     "",
+				Attachments:       []string{"testdata/vela.png", "testdata/reports/*.xml"},
+				AttachmentMaxSize: "16KB",
+			},
+			wantErr: ErrorAttachmentsTooLarge,
+		},
+		{
+			name: "Email attachment max size malformed",
+			parameters: Plugin{
+				Email:             mockEmail,
+				EmailFilename:     "",
+				Attachments:       []string{"testdata/vela.png"},
+				AttachmentMaxSize: "ten megabytes",
+			},
+			wantErr: ErrorInvalidSize,
+		},
+		{
+			name: "HTML template file missing",
+			parameters: Plugin{
+				Email:        &email.Email{},
+				HTMLFilename: "testdata/templates/doesnotexist.html",
+			},
+			wantErr: os.ErrNotExist,
+		},
+		{
+			name: "Text template file empty",
+			parameters: Plugin{
+				Email:        &email.Email{},
+				TextFilename: "testdata/empty.txt",
 			},
 			wantErr: ErrorEmptyFile,
 		},
+		{
+			name: "HTML provided inline and from template file",
+			parameters: Plugin{
+				Email: &email.Email{
+					HTML: []byte("<p>inline</p>"),
+				},
+				HTMLFilename: "testdata/templates/build.html",
+			},
+			wantErr: ErrorConflictingBodyParams,
+		},
+		{
+			name: "Inline images without html body",
+			parameters: Plugin{
+				Email: &email.Email{
+					To:   []string{"fakemail1@example.com"},
+					From: "fakemail2@example.com",
+					Text: []byte("text only"),
+				},
+				SMTPHost:     mockSMTPHost,
+				InlineImages: []string{"testdata/vela.png"},
+			},
+			wantErr: ErrorInlineImagesWithoutHTML,
+		},
+		{
+			name: "Email attachment pattern matches nothing",
+			parameters: Plugin{
+				Email:         mockEmail,
+				EmailFilename: "",
+				Attachments:   []string{"testdata/vela.png", "testdata/reports/**/*.html"},
+			},
+			wantErr: ErrorAttachmentNoMatch,
+		},
+		{
+			name: "Email attachment pattern malformed",
+			parameters: Plugin{
+				Email:         mockEmail,
+				EmailFilename: "",
+				Attachments:   []string{"testdata/[.txt"},
+			},
+			wantErr: filepath.ErrBadPattern,
+		},
 		{
 			name: "SMTP host missing",
 			parameters: Plugin{
@@ -283,7 +362,6 @@ func TestValidateErrors(t *testing.T) {
 				SMTPHost: &SMTPHost{
 					Port: "1902",
 				},
-				Attachment: noAttachment,
 			},
 			wantErr: ErrorMissingSMTPParam,
 		},
@@ -293,9 +371,8 @@ func TestValidateErrors(t *testing.T) {
 				Email:         mockEmail,
 				EmailFilename: "",
 				SMTPHost: &SMTPHost{
-					Host: "smtphost.com",
+					Hosts: []string{"smtphost.com"},
 				},
-				Attachment: noAttachment,
 			},
 			wantErr: ErrorMissingSMTPParam,
 		},
@@ -304,15 +381,44 @@ func TestValidateErrors(t *testing.T) {
 			parameters: Plugin{
 				Auth: "LoginAuth",
 				SMTPHost: &SMTPHost{
-					Host: "smtphost.com",
-					Port: "234234",
+					Hosts: []string{"smtphost.com"},
+					Port:  "234234",
 				},
 				Email:         mockEmail,
 				EmailFilename: "",
-				Attachment:    noAttachment,
 			},
 			wantErr: ErrorAuthSpecifiedButCredentialsMissing,
 		},
+		{
+			name: "Retries negative",
+			parameters: Plugin{
+				Email:    mockEmail,
+				SMTPHost: mockSMTPHost,
+				Retry:    &Retry{Retries: -1},
+			},
+			wantErr: ErrorInvalidRetryParam,
+		},
+		{
+			name: "TLS required with a plain relay",
+			parameters: Plugin{
+				Email: mockEmail,
+				SMTPHost: &SMTPHost{
+					Hosts: []string{"plain://smtphost.com"},
+					Port:  "25",
+				},
+				RequireTLS: true,
+			},
+			wantErr: ErrorTLSRequired,
+		},
+		{
+			name: "Helo name with whitespace",
+			parameters: Plugin{
+				Email:    mockEmail,
+				SMTPHost: mockSMTPHost,
+				HeloName: "mail example.com",
+			},
+			wantErr: ErrorInvalidHeloName,
+		},
 	}
 
 	for _, test := range tests {
@@ -326,10 +432,225 @@ func TestValidateErrors(t *testing.T) {
 	}
 }
 
+func TestExpandAttachments(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		want     []string
+	}{
+		{
+			name:     "literal paths",
+			patterns: []string{"testdata/vela.png", "testdata/example1.txt"},
+			want:     []string{"testdata/vela.png", "testdata/example1.txt"},
+		},
+		{
+			name:     "single directory glob",
+			patterns: []string{"testdata/reports/*"},
+			want:     []string{"testdata/reports/build.log", "testdata/reports/junit.xml"},
+		},
+		{
+			name:     "recursive glob",
+			patterns: []string{"testdata/reports/**/*.xml"},
+			want:     []string{"testdata/reports/junit.xml", "testdata/reports/unit/coverage.xml"},
+		},
+		{
+			name:     "overlapping patterns are de-duplicated",
+			patterns: []string{"testdata/reports/junit.xml", "testdata/**/*.xml"},
+			want:     []string{"testdata/reports/junit.xml", "testdata/reports/unit/coverage.xml"},
+		},
+		{
+			name:     "empty files matched by a glob are skipped",
+			patterns: []string{"testdata/*.txt"},
+			want:     []string{"testdata/badattachment.txt", "testdata/example1.txt"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := expandAttachments(test.patterns)
+			if err != nil {
+				t.Errorf("expandAttachments() should not have raised an error %s", err)
+			}
+
+			if !slices.Equal(got, test.want) {
+				t.Errorf("expandAttachments() is %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateAttachmentBundle(t *testing.T) {
+	// a highly compressible log larger than the limit
+	log := filepath.Join(t.TempDir(), "build.log")
+
+	err := os.WriteFile(log, []byte(strings.Repeat("ok  github.com/go-vela/vela-email 0.142s\n", 2048)), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := Plugin{
+		Email: &email.Email{
+			To:   []string{"fakemail1@example.com"},
+			From: "fakemail2@example.com",
+		},
+		SMTPHost:          mockSMTPHost,
+		Attachments:       []string{log, "testdata/reports/**/*.xml"},
+		AttachmentMaxSize: "16KB",
+	}
+
+	if err := p.Validate(); err != nil {
+		t.Errorf("Validate() should not have raised an error %s", err)
+		t.FailNow()
+	}
+
+	if len(p.Email.Attachments) != 1 {
+		t.Fatalf("Validate() attached %d files, want 1", len(p.Email.Attachments))
+	}
+
+	bundle := p.Email.Attachments[0]
+	if bundle.Filename != AttachmentBundleName {
+		t.Errorf("Validate() attached %s, want %s", bundle.Filename, AttachmentBundleName)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(bundle.Content), int64(len(bundle.Content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(archive.File) != 3 {
+		t.Errorf("%s contains %d files, want 3", AttachmentBundleName, len(archive.File))
+	}
+}
+
+func TestValidateAttachmentEncodedSize(t *testing.T) {
+	// random data does not compress and fits the limit only before it is encoded
+	data := make([]byte, 15<<10)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(t.TempDir(), "random.bin")
+	if err := os.WriteFile(file, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	p := Plugin{
+		Email: &email.Email{
+			To:   []string{"fakemail1@example.com"},
+			From: "fakemail2@example.com",
+		},
+		SMTPHost:          mockSMTPHost,
+		Attachments:       []string{file},
+		AttachmentMaxSize: "16KB",
+	}
+
+	if err := p.Validate(); !errors.Is(err, ErrorAttachmentsTooLarge) {
+		t.Errorf("Validate() error = %v, wantErr = %v", err, ErrorAttachmentsTooLarge)
+	}
+}
+
+func TestEncodedSize(t *testing.T) {
+	tests := []struct {
+		size int64
+		want int64
+	}{
+		{size: 0, want: 0},
+		{size: 1, want: 6},
+		{size: 57, want: 78},
+		{size: 58, want: 84},
+		{size: 3 << 10, want: 4<<10 + 108},
+	}
+
+	for _, test := range tests {
+		if got := encodedSize(test.size); got != test.want {
+			t.Errorf("encodedSize(%d) is %d, want %d", test.size, got, test.want)
+		}
+	}
+}
+
+func TestValidateInlineImages(t *testing.T) {
+	p := Plugin{
+		Email: &email.Email{
+			To:   []string{"fakemail1@example.com"},
+			From: "fakemail2@example.com",
+			HTML: []byte(`<img src="cid:vela.png" alt="Vela">`),
+		},
+		SMTPHost:     mockSMTPHost,
+		Attachments:  []string{"testdata/example1.txt"},
+		InlineImages: []string{"testdata/*.png"},
+	}
+
+	if err := p.Validate(); err != nil {
+		t.Errorf("Validate() should not have raised an error %s", err)
+		t.FailNow()
+	}
+
+	msg, err := p.Email.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"multipart/related", "Content-Id: <vela.png>", "inline;\r\n filename=\"vela.png\""} {
+		if !bytes.Contains(msg, []byte(want)) {
+			t.Errorf("Email.Bytes() is missing %q", want)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		size    string
+		want    int64
+		wantErr bool
+	}{
+		{size: "", want: 0},
+		{size: "2048", want: 2048},
+		{size: "512B", want: 512},
+		{size: "10KB", want: 10 << 10},
+		{size: "10MB", want: 10 << 20},
+		{size: "1.5 mb", want: 3 << 19},
+		{size: "2GiB", want: 2 << 30},
+		{size: "10M", want: 10 << 20},
+		{size: "ten", wantErr: true},
+		{size: "-1MB", wantErr: true},
+		{size: "NaN", wantErr: true},
+		{size: "Inf", wantErr: true},
+		{size: "-Inf MB", wantErr: true},
+		{size: "1e400", wantErr: true},
+		{size: "9223372036854775807", wantErr: true},
+		{size: "9e9GB", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.size, func(t *testing.T) {
+			got, err := parseSize(test.size)
+			if test.wantErr {
+				if !errors.Is(err, ErrorInvalidSize) {
+					t.Errorf("parseSize() error = %v, wantErr = %v", err, ErrorInvalidSize)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Errorf("parseSize() should not have raised an error %s", err)
+			}
+
+			if got != test.want {
+				t.Errorf("parseSize() is %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
 func TestInjectEnvSuccess(t *testing.T) {
 	tests := []struct {
-		name       string
-		parameters Plugin
+		name        string
+		parameters  Plugin
+		message     string
+		wantSubject string
+		wantText    string
+		wantHTML    string
 	}{
 		{
 			name:       "email using empty subject and html",
@@ -346,7 +667,19 @@ func TestInjectEnvSuccess(t *testing.T) {
 				},
 				EmailFilename: "",
 				SMTPHost:      mockSMTPHost,
-				Attachment:    noAttachment,
+				BuildEnv:      mockBuildEnv,
+			},
+		},
+		{
+			name: "email using html template file",
+			parameters: Plugin{
+				Email: &email.Email{
+					To:   []string{"fakemail1@example.com", "fakemail2@example.com"},
+					From: "fakemail3@example.com",
+				},
+				EmailFilename: "",
+				HTMLFilename:  "testdata/templates/build.html",
+				SMTPHost:      mockSMTPHost,
 				BuildEnv:      mockBuildEnv,
 			},
 		},
@@ -361,10 +694,27 @@ func TestInjectEnvSuccess(t *testing.T) {
 				},
 				EmailFilename: "",
 				SMTPHost:      mockSMTPHost,
-				Attachment:    noAttachment,
 				BuildEnv:      mockBuildEnv,
 			},
 		},
+		{
+			name: "email using commit message with special characters",
+			parameters: Plugin{
+				Email: &email.Email{
+					To:      []string{"fakemail1@example.com"},
+					From:    "fakemail3@example.com",
+					Subject: "Build failed: {{ .VELA_BUILD_MESSAGE }}",
+					Text:    []byte("Message: {{ .VELA_BUILD_MESSAGE }}"),
+					HTML:    []byte(`<p title="{{ .VELA_BUILD_MESSAGE }}">{{ .VELA_BUILD_MESSAGE }}</p>`),
+				},
+				SMTPHost: mockSMTPHost,
+				BuildEnv: mockBuildEnv,
+			},
+			message:     `fix <T> & "quotes"`,
+			wantSubject: `Build failed: fix <T> & "quotes"`,
+			wantText:    `Message: fix <T> & "quotes"`,
+			wantHTML:    `<p title="fix &lt;T&gt; &amp; &#34;quotes&#34;">fix &lt;T&gt; &amp; &#34;quotes&#34;</p>`,
+		},
 	}
 
 	for _, test := range tests {
@@ -376,6 +726,10 @@ func TestInjectEnvSuccess(t *testing.T) {
 
 			createMockEnv(t)
 
+			if len(test.message) > 0 {
+				t.Setenv("VELA_BUILD_MESSAGE", test.message)
+			}
+
 			subject, err := test.parameters.injectEnv(test.parameters.Email.Subject)
 			if err != nil {
 				t.Errorf("InjectEnv(subject) should not have raised an error %s", err)
@@ -386,25 +740,196 @@ func TestInjectEnvSuccess(t *testing.T) {
 				t.Errorf("InjectEnv(subject) failed to inject all environment variables %s", subject)
 			}
 
-			var body string
-			if len(test.parameters.Email.HTML) == 0 {
-				body, err = test.parameters.injectEnv(string(test.parameters.Email.HTML))
-			} else {
-				body, err = test.parameters.injectEnv(string(test.parameters.Email.Text))
+			if !strings.Contains(subject, test.wantSubject) {
+				t.Errorf("InjectEnv(subject) is %q, want it to contain %q", subject, test.wantSubject)
 			}
 
+			text, err := test.parameters.injectEnv(string(test.parameters.Email.Text))
 			if err != nil {
-				t.Errorf("InjectEnv(body) should not have raised an error %s", err)
+				t.Errorf("InjectEnv(text) should not have raised an error %s", err)
 				t.FailNow()
 			}
 
-			if strings.Contains(body, "<no value>") {
-				t.Errorf("InjectEnv(body) failed to inject all environment variables %s", body)
+			if strings.Contains(text, "<no value>") {
+				t.Errorf("InjectEnv(text) failed to inject all environment variables %s", text)
+			}
+
+			if !strings.Contains(text, test.wantText) {
+				t.Errorf("InjectEnv(text) is %q, want it to contain %q", text, test.wantText)
+			}
+
+			html, err := test.parameters.injectEnvHTML(string(test.parameters.Email.HTML))
+			if err != nil {
+				t.Errorf("InjectEnvHTML(html) should not have raised an error %s", err)
+				t.FailNow()
+			}
+
+			if strings.Contains(html, "<no value>") {
+				t.Errorf("InjectEnvHTML(html) failed to inject all environment variables %s", html)
+			}
+
+			if !strings.Contains(html, test.wantHTML) {
+				t.Errorf("InjectEnvHTML(html) is %q, want it to contain %q", html, test.wantHTML)
 			}
 		})
 	}
 }
 
+func TestRenderAlternative(t *testing.T) {
+	tests := []struct {
+		name         string
+		email        *email.Email
+		textFromHTML bool
+		wantText     string
+		wantHTML     string
+	}{
+		{
+			name: "html and text are both rendered",
+			email: &email.Email{
+				HTML: []byte("<p>{{ .VELA_REPO_FULL_NAME }}</p>"),
+				Text: []byte("repo: {{ .VELA_REPO_FULL_NAME }}"),
+			},
+			wantText: "repo: octocat/hello-world",
+			wantHTML: "<p>octocat/hello-world</p>",
+		},
+		{
+			name: "text generated from html",
+			email: &email.Email{
+				HTML: []byte(`<p>Build <a href="{{ .VELA_BUILD_LINK }}">{{ .VELA_BUILD_NUMBER }}</a></p>`),
+			},
+			textFromHTML: true,
+			wantText:     "Build 1 [1]\n\n[1] https://vela-server.localhost/octocat/hello-world/1\n",
+			wantHTML:     `<p>Build <a href="https://vela-server.localhost/octocat/hello-world/1">1</a></p>`,
+		},
+		{
+			name: "provided text wins over generated text",
+			email: &email.Email{
+				HTML: []byte("<p>{{ .VELA_BUILD_NUMBER }}</p>"),
+				Text: []byte("build {{ .VELA_BUILD_NUMBER }}"),
+			},
+			textFromHTML: true,
+			wantText:     "build 1",
+			wantHTML:     "<p>1</p>",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			createMockEnv(t)
+
+			p := Plugin{
+				Email:        test.email,
+				TextFromHTML: test.textFromHTML,
+				BuildEnv:     mockBuildEnv,
+			}
+
+			if err := p.render(); err != nil {
+				t.Errorf("render() should not have raised an error %s", err)
+				t.FailNow()
+			}
+
+			if string(p.Email.Text) != test.wantText {
+				t.Errorf("render() text is %q, want %q", p.Email.Text, test.wantText)
+			}
+
+			if !strings.Contains(string(p.Email.HTML), test.wantHTML) {
+				t.Errorf("render() html is %q, want %q", p.Email.HTML, test.wantHTML)
+			}
+
+			msg, err := p.Email.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Contains(msg, []byte("multipart/alternative")) {
+				t.Errorf("Email.Bytes() is not multipart/alternative")
+			}
+		})
+	}
+}
+
+func TestRenderHeaders(t *testing.T) {
+	createMockEnv(t)
+	t.Setenv("VELA_BUILD_MESSAGE", `fix <T> & "quotes"`)
+
+	p := Plugin{
+		Email: &email.Email{
+			Subject: "{{ .VELA_BUILD_MESSAGE }}",
+			Text:    []byte("{{ .VELA_BUILD_MESSAGE }}"),
+			Headers: map[string][]string{
+				"X-Vela-Build": {"{{ .VELA_REPO_FULL_NAME }}#{{ .VELA_BUILD_NUMBER }}"},
+				"X-Vela-Note":  {"{{ .VELA_BUILD_MESSAGE }}"},
+			},
+		},
+		BuildEnv: mockBuildEnv,
+	}
+
+	if err := p.render(); err != nil {
+		t.Errorf("render() should not have raised an error %s", err)
+		t.FailNow()
+	}
+
+	want := map[string]string{
+		"X-Vela-Build": "octocat/hello-world#1",
+		"X-Vela-Note":  `fix <T> & "quotes"`,
+	}
+
+	for key, value := range want {
+		if got := p.Email.Headers.Get(key); got != value {
+			t.Errorf("render() header %s is %q, want %q", key, got, value)
+		}
+	}
+
+	if p.Email.Subject != `fix <T> & "quotes"` {
+		t.Errorf("render() subject is %q, want it unescaped", p.Email.Subject)
+	}
+}
+
+func TestExecDryRun(t *testing.T) {
+	createMockEnv(t)
+
+	output := filepath.Join(t.TempDir(), "preview", "email.eml")
+
+	p := Plugin{
+		Email: &email.Email{
+			To:      []string{"fakemail1@example.com"},
+			From:    "fakemail3@example.com",
+			Subject: "{{ .VELA_REPO_FULL_NAME }} {{ .VELA_BUILD_BRANCH }}",
+			Text:    []byte("Build {{ .VELA_BUILD_NUMBER }}"),
+		},
+		SMTPHost:     &SMTPHost{},
+		BuildEnv:     mockBuildEnv,
+		DryRun:       true,
+		DryRunOutput: output,
+	}
+
+	if err := p.Validate(); err != nil {
+		t.Errorf("Validate() should not have raised an error %s", err)
+		t.FailNow()
+	}
+
+	if err := p.Exec(); err != nil {
+		t.Errorf("Exec() should not have raised an error %s", err)
+		t.FailNow()
+	}
+
+	msg, err := os.ReadFile(output)
+	if err != nil {
+		t.Errorf("ReadFile() should not have raised an error %s", err)
+		t.FailNow()
+	}
+
+	for _, want := range []string{
+		"To: <fakemail1@example.com>",
+		"Subject: octocat/hello-world main",
+		"Build 1",
+	} {
+		if !strings.Contains(string(msg), want) {
+			t.Errorf("Exec() dry run output is missing %q:\n%s", want, msg)
+		}
+	}
+}
+
 func TestInjectEnvBadVar(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -420,7 +945,6 @@ func TestInjectEnvBadVar(t *testing.T) {
 				},
 				EmailFilename: "",
 				SMTPHost:      mockSMTPHost,
-				Attachment:    noAttachment,
 				BuildEnv:      mockBuildEnv,
 			},
 		},
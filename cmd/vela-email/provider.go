@@ -0,0 +1,197 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+)
+
+// providers maps the lower case send types of the
+// email provider HTTP APIs to their names.
+var providers = map[string]string{
+	"sendgrid": "SendGrid",
+	"mailgun":  "Mailgun",
+	"ses":      "SES",
+}
+
+// API represents the configuration for sending
+// through the HTTP API of an email provider.
+type API struct {
+	// Endpoint overrides the base URL of the provider API
+	Endpoint string
+	// Key is the SendGrid or Mailgun API key
+	Key string
+	// Domain is the Mailgun sending domain
+	Domain string
+	// Region is the AWS region used for SES
+	Region string
+	// AccessKeyID is the AWS access key ID used for SES
+	AccessKeyID string
+	// SecretAccessKey is the AWS secret access key used for SES
+	SecretAccessKey string
+	// SessionToken is the optional AWS session token used for SES
+	SessionToken string
+}
+
+// validate checks the parameters required by the provider are provided.
+func (a *API) validate(provider string) error {
+	if a == nil {
+		a = &API{}
+	}
+
+	var missing []string
+
+	switch provider {
+	case "SendGrid":
+		if len(a.Key) == 0 {
+			missing = append(missing, "api_key")
+		}
+	case "Mailgun":
+		if len(a.Key) == 0 {
+			missing = append(missing, "api_key")
+		}
+
+		if len(a.Domain) == 0 {
+			missing = append(missing, "api_domain")
+		}
+	case "SES":
+		if len(a.Region) == 0 {
+			missing = append(missing, "aws_region")
+		}
+
+		if len(a.AccessKeyID) == 0 || len(a.SecretAccessKey) == 0 {
+			missing = append(missing, "aws_access_key_id", "aws_secret_access_key")
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s requires %s", ErrorMissingProviderParam, provider, strings.Join(missing, ", "))
+	}
+
+	if len(a.Endpoint) > 0 {
+		u, err := url.Parse(a.Endpoint)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || len(u.Host) == 0 {
+			return fmt.Errorf("%w: invalid api_endpoint %s", ErrorMissingProviderParam, a.Endpoint)
+		}
+	}
+
+	return nil
+}
+
+// sender returns the sender for the provider.
+func (a *API) sender(provider string, client *http.Client) (Sender, error) {
+	if err := a.validate(provider); err != nil {
+		return nil, err
+	}
+
+	switch provider {
+	case "SendGrid":
+		return &sendGrid{api: a, client: client}, nil
+	case "Mailgun":
+		return &mailgun{api: a, client: client}, nil
+	default:
+		return &ses{api: a, client: client}, nil
+	}
+}
+
+// endpoint returns the base URL of the provider API,
+// using the fallback when no endpoint is provided.
+func (a *API) endpoint(fallback string) string {
+	if len(a.Endpoint) > 0 {
+		return strings.TrimSuffix(a.Endpoint, "/")
+	}
+
+	return fallback
+}
+
+// do sends the request to the provider and maps error responses
+// to plugin errors. Rejected credentials, rate limits and server
+// errors are reported separately so only the latter are retried.
+func do(client *http.Client, provider string, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s request failed: %w", provider, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("%s response failed: %w", provider, err)
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return body, nil
+	}
+
+	var sentinel error
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		sentinel = ErrorProviderAuth
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		sentinel = ErrorProviderUnavailable
+	default:
+		sentinel = ErrorProviderRejected
+	}
+
+	if msg := providerMessage(body); len(msg) > 0 {
+		return nil, fmt.Errorf("%w: %s returned %s: %s", sentinel, provider, resp.Status, msg)
+	}
+
+	return nil, fmt.Errorf("%w: %s returned %s", sentinel, provider, resp.Status)
+}
+
+// providerMessage returns the error message from the response body,
+// understanding the JSON errors of the supported providers.
+func providerMessage(body []byte) string {
+	var resp struct {
+		Message string `json:"message"`
+		Errors  []struct {
+			Field   string `json:"field"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+
+	if err := json.Unmarshal(body, &resp); err != nil {
+		// truncate on characters so multi-byte runes are not split
+		return truncate(203, strings.TrimSpace(string(body)))
+	}
+
+	var msgs []string
+
+	if len(resp.Message) > 0 {
+		msgs = append(msgs, resp.Message)
+	}
+
+	for _, e := range resp.Errors {
+		if len(e.Field) > 0 {
+			msgs = append(msgs, e.Field+": "+e.Message)
+		} else {
+			msgs = append(msgs, e.Message)
+		}
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// addresses returns the addresses without their display names.
+func addresses(list []string) ([]string, error) {
+	var addrs []string
+
+	for _, entry := range list {
+		addr, err := mail.ParseAddress(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient %s: %w", entry, err)
+		}
+
+		addrs = append(addrs, addr.Address)
+	}
+
+	return addrs, nil
+}
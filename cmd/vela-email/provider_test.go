@@ -0,0 +1,363 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jordan-wright/email"
+)
+
+// fakeProvider is an email provider API recording the requests it receives.
+type fakeProvider struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+	statuses []int
+	response string
+}
+
+// newFakeProvider starts a provider replying with the statuses in
+// order, and then with 200 OK and the response to later requests.
+func newFakeProvider(t *testing.T, response string, statuses ...int) *fakeProvider {
+	t.Helper()
+
+	f := &fakeProvider{statuses: statuses, response: response}
+
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		f.requests = append(f.requests, r.Clone(context.Background()))
+		f.bodies = append(f.bodies, body)
+
+		status := http.StatusOK
+		if len(f.statuses) > 0 {
+			status, f.statuses = f.statuses[0], f.statuses[1:]
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(f.response))
+	}))
+	t.Cleanup(f.Close)
+
+	return f
+}
+
+// received returns the requests and their bodies.
+func (f *fakeProvider) received() ([]*http.Request, [][]byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.requests, f.bodies
+}
+
+func TestSignV4(t *testing.T) {
+	// get-vanilla from the AWS Signature Version 4 test suite
+	req, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatalf("NewRequest() should not have raised an error %s", err)
+	}
+
+	api := &API{Region: "us-east-1", AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+
+	signV4(req, nil, api, "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("signV4() Authorization is %q, want %q", got, want)
+	}
+
+	if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+		t.Errorf("signV4() X-Amz-Date is %q, want 20150830T123600Z", got)
+	}
+}
+
+func TestCanonicalQuery(t *testing.T) {
+	query := map[string][]string{"a-b": {"1"}, "a": {"2", "1 2"}, "c": {"~x/y"}}
+
+	if got, want := canonicalQuery(query), "a=1%202&a=2&a-b=1&c=~x%2Fy"; got != want {
+		t.Errorf("canonicalQuery() is %q, want %q", got, want)
+	}
+}
+
+func TestExecProviders(t *testing.T) {
+	tests := []struct {
+		sendType string
+		api      API
+		response string
+		check    func(t *testing.T, r *http.Request, body []byte)
+	}{
+		{
+			sendType: "SendGrid",
+			api:      API{Key: "sg-key"},
+			check: func(t *testing.T, r *http.Request, body []byte) {
+				if r.URL.Path != "/v3/mail/send" || r.Header.Get("Authorization") != "Bearer sg-key" {
+					t.Errorf("SendGrid request is %s with %q", r.URL.Path, r.Header.Get("Authorization"))
+				}
+
+				var req sendGridRequest
+				if err := json.Unmarshal(body, &req); err != nil {
+					t.Fatalf("Unmarshal() should not have raised an error %s", err)
+				}
+
+				p := req.Personalizations[0]
+				if req.From.Email != "fakemail2@example.com" || req.From.Name != "Vela" || p.To[0].Email != "fakemail1@example.com" || p.Bcc[0].Email != "hidden@example.com" {
+					t.Errorf("SendGrid addresses are %+v from %+v", p, req.From)
+				}
+
+				if req.Subject != "subject" || len(req.Content) != 2 || req.Content[0].Type != "text/plain" || req.Content[1].Type != "text/html" {
+					t.Errorf("SendGrid content is %q %+v", req.Subject, req.Content)
+				}
+
+				if len(req.Attachments) != 1 || req.Attachments[0].Filename != "report.txt" || req.Attachments[0].Content != base64.StdEncoding.EncodeToString([]byte("report")) {
+					t.Errorf("SendGrid attachments are %+v", req.Attachments)
+				}
+
+				if req.Headers["X-Vela-Build"] != "1" || len(req.Headers) != 1 {
+					t.Errorf("SendGrid headers are %v", req.Headers)
+				}
+			},
+		},
+		{
+			sendType: "Mailgun",
+			api:      API{Key: "mg-key", Domain: "mg.example.com"},
+			response: `{"id":"<id@mg.example.com>","message":"Queued. Thank you."}`,
+			check: func(t *testing.T, r *http.Request, body []byte) {
+				r.Body = io.NopCloser(bytes.NewReader(body))
+				if err := r.ParseMultipartForm(1 << 20); err != nil {
+					t.Fatalf("ParseMultipartForm() should not have raised an error %s", err)
+				}
+
+				if user, pass, _ := r.BasicAuth(); r.URL.Path != "/v3/mg.example.com/messages.mime" || user != "api" || pass != "mg-key" {
+					t.Errorf("Mailgun request is %s as %s:%s", r.URL.Path, user, pass)
+				}
+
+				if to := r.MultipartForm.Value["to"]; !slices.Equal(to, []string{"fakemail1@example.com", "hidden@example.com"}) {
+					t.Errorf("Mailgun recipients are %q", to)
+				}
+
+				file, err := r.MultipartForm.File["message"][0].Open()
+				if err != nil {
+					t.Fatalf("Open() should not have raised an error %s", err)
+				}
+
+				raw, _ := io.ReadAll(file)
+				if !strings.Contains(string(raw), "Subject: subject") || strings.Contains(string(raw), "hidden@example.com") {
+					t.Errorf("Mailgun message is %q, want it without the Bcc header", raw)
+				}
+			},
+		},
+		{
+			sendType: "SES",
+			api:      API{Region: "eu-west-1", AccessKeyID: "AKID", SecretAccessKey: "secret", SessionToken: "session"},
+			response: `{"MessageId":"0100018f"}`,
+			check: func(t *testing.T, r *http.Request, body []byte) {
+				auth := r.Header.Get("Authorization")
+				if r.URL.Path != "/v2/email/outbound-emails" || !strings.Contains(auth, "/eu-west-1/ses/aws4_request, SignedHeaders=content-type;host;x-amz-date;x-amz-security-token, ") {
+					t.Errorf("SES request is %s with %q", r.URL.Path, auth)
+				}
+
+				var req sesRequest
+				if err := json.Unmarshal(body, &req); err != nil {
+					t.Fatalf("Unmarshal() should not have raised an error %s", err)
+				}
+
+				if req.FromEmailAddress != "fakemail2@example.com" || !slices.Equal(req.Destination.BccAddresses, []string{"hidden@example.com"}) {
+					t.Errorf("SES request is from %s to %+v", req.FromEmailAddress, req.Destination)
+				}
+
+				if !strings.Contains(string(req.Content.Raw.Data), "Subject: subject") {
+					t.Errorf("SES message is %q", req.Content.Raw.Data)
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sendType, func(t *testing.T) {
+			server := newFakeProvider(t, test.response)
+
+			api := test.api
+			api.Endpoint = server.URL
+
+			p := &Plugin{
+				Email: &email.Email{
+					To:      []string{"fakemail1@example.com"},
+					Bcc:     []string{"hidden@example.com"},
+					From:    "Vela <fakemail2@example.com>",
+					Subject: "subject",
+					Text:    []byte("body"),
+					HTML:    []byte("<p>body</p>"),
+					Headers: map[string][]string{"X-Vela-Build": {"1"}},
+				},
+				SendType: test.sendType,
+				API:      &api,
+				BuildEnv: mockBuildEnv,
+			}
+
+			if _, err := p.Email.Attach(strings.NewReader("report"), "report.txt", "text/plain"); err != nil {
+				t.Fatalf("Attach() should not have raised an error %s", err)
+			}
+
+			if err := p.Validate(); err != nil {
+				t.Errorf("Validate() should not have raised an error %s", err)
+			}
+
+			if err := p.Exec(); err != nil {
+				t.Errorf("Exec() should not have raised an error %s", err)
+			}
+
+			requests, bodies := server.received()
+			if len(requests) != 1 {
+				t.Fatalf("Exec() sent %d requests, want 1", len(requests))
+			}
+
+			test.check(t, requests[0], bodies[0])
+		})
+	}
+}
+
+func TestProviderMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{name: "json errors", body: `{"message":"Forbidden","errors":[{"field":"to","message":"is invalid"}]}`, want: "Forbidden; to: is invalid"},
+		{name: "short text", body: " upstream unavailable\n", want: "upstream unavailable"},
+		{name: "multi-byte text under the limit", body: strings.Repeat("é", 200), want: strings.Repeat("é", 200)},
+		{name: "multi-byte text over the limit", body: strings.Repeat("é", 250), want: strings.Repeat("é", 200) + "..."},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := providerMessage([]byte(test.body))
+			if got != test.want {
+				t.Errorf("providerMessage() is %q, want %q", got, test.want)
+			}
+
+			if !utf8.ValidString(got) {
+				t.Errorf("providerMessage() is %q, want valid UTF-8", got)
+			}
+		})
+	}
+}
+
+func TestProviderErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		response     string
+		wantAttempts int
+		wantErr      error
+		wantMsg      string
+	}{
+		{
+			name:         "invalid key",
+			statuses:     []int{http.StatusUnauthorized},
+			response:     `{"errors":[{"message":"The provided authorization grant is invalid, expired, or revoked"}]}`,
+			wantAttempts: 1,
+			wantErr:      ErrorProviderAuth,
+			wantMsg:      "SendGrid returned 401 Unauthorized: The provided authorization grant is invalid",
+		},
+		{
+			name:         "rejected email",
+			statuses:     []int{http.StatusBadRequest},
+			response:     `{"errors":[{"field":"from.email","message":"does not contain a valid address."}]}`,
+			wantAttempts: 1,
+			wantErr:      ErrorProviderRejected,
+			wantMsg:      "from.email: does not contain a valid address.",
+		},
+		{
+			name:         "rate limited then accepted",
+			statuses:     []int{http.StatusTooManyRequests},
+			wantAttempts: 2,
+		},
+		{
+			name:         "unavailable",
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusBadGateway},
+			response:     `upstream unavailable`,
+			wantAttempts: 2,
+			wantErr:      ErrorProviderUnavailable,
+			wantMsg:      "502 Bad Gateway: upstream unavailable",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeProvider(t, test.response, test.statuses...)
+
+			p := &Plugin{
+				Email: &email.Email{
+					To:      []string{"fakemail1@example.com"},
+					From:    "fakemail2@example.com",
+					Subject: "subject",
+					Text:    []byte("body"),
+				},
+				SendType: "sendgrid",
+				API:      &API{Key: "sg-key", Endpoint: server.URL},
+				Retry:    &Retry{Retries: 1},
+				BuildEnv: mockBuildEnv,
+			}
+
+			err := p.Exec()
+			if !errors.Is(err, test.wantErr) || (err != nil && !strings.Contains(err.Error(), test.wantMsg)) {
+				t.Errorf("Exec() error is %v, want %v containing %q", err, test.wantErr, test.wantMsg)
+			}
+
+			if requests, _ := server.received(); len(requests) != test.wantAttempts {
+				t.Errorf("Exec() sent %d requests, want %d", len(requests), test.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestValidateProvider(t *testing.T) {
+	tests := []struct {
+		sendType string
+		api      *API
+		wantErr  error
+	}{
+		{sendType: "SendGrid", wantErr: ErrorMissingProviderParam},
+		{sendType: "SendGrid", api: &API{Key: "key"}},
+		{sendType: "SendGrid", api: &API{Key: "key", Endpoint: "api.sendgrid.com"}, wantErr: ErrorMissingProviderParam},
+		{sendType: "Mailgun", api: &API{Key: "key"}, wantErr: ErrorMissingProviderParam},
+		{sendType: "Mailgun", api: &API{Key: "key", Domain: "mg.example.com", Endpoint: "https://api.eu.mailgun.net"}},
+		{sendType: "SES", api: &API{Region: "us-east-1", AccessKeyID: "AKID"}, wantErr: ErrorMissingProviderParam},
+		{sendType: "SES", api: &API{Region: "us-east-1", AccessKeyID: "AKID", SecretAccessKey: "secret"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sendType, func(t *testing.T) {
+			p := &Plugin{
+				Email:    &email.Email{To: []string{"fakemail1@example.com"}, From: "fakemail2@example.com"},
+				SendType: test.sendType,
+				API:      test.api,
+			}
+
+			if err := p.Validate(); !errors.Is(err, test.wantErr) {
+				t.Errorf("Validate() error = %v, wantErr = %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// sendTypes maps the lower case send types to their names.
+var sendTypes = map[string]string{
+	"auto":     "Auto",
+	"plain":    "Plain",
+	"starttls": "StartTLS",
+	"tls":      "TLS",
+}
+
+// Relay represents an SMTP host the email can be sent through.
+type Relay struct {
+	Host     string
+	Port     string
+	SendType string
+}
+
+// String returns the host:port of the relay.
+func (r Relay) String() string {
+	return net.JoinHostPort(r.Host, r.Port)
+}
+
+// relays parses the hosts into the ordered list of relays to try.
+// Each host is formatted as [sendtype://]host[:port] and falls back
+// to the port and send type parameters when they are omitted.
+func (h *SMTPHost) relays(sendType string) ([]Relay, error) {
+	if h == nil {
+		return nil, ErrorMissingSMTPParam
+	}
+
+	var relays []Relay
+
+	for _, entry := range h.Hosts {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+
+		relay, err := parseRelay(entry, h.Port, sendType)
+		if err != nil {
+			return nil, err
+		}
+
+		relays = append(relays, relay)
+	}
+
+	if len(relays) == 0 {
+		return nil, ErrorMissingSMTPParam
+	}
+
+	return relays, nil
+}
+
+// parseRelay parses a single [sendtype://]host[:port] entry.
+func parseRelay(entry, port, sendType string) (Relay, error) {
+	relay := Relay{Host: entry, Port: port, SendType: sendTypes[strings.ToLower(sendType)]}
+
+	if scheme, rest, ok := strings.Cut(entry, "://"); ok {
+		relay.Host, relay.SendType = rest, sendTypes[strings.ToLower(scheme)]
+
+		if len(relay.SendType) == 0 {
+			return Relay{}, fmt.Errorf("%w: unknown send type %s in %s", ErrorInvalidSMTPHost, scheme, entry)
+		}
+	}
+
+	// the send type parameter has always fallen back to Plain
+	if len(relay.SendType) == 0 {
+		relay.SendType = "Plain"
+	}
+
+	if host, port, err := net.SplitHostPort(relay.Host); err == nil {
+		relay.Host, relay.Port = host, port
+	} else {
+		relay.Host = strings.TrimSuffix(strings.TrimPrefix(relay.Host, "["), "]")
+	}
+
+	if len(relay.Host) == 0 || len(relay.Port) == 0 {
+		return Relay{}, fmt.Errorf("%w: %s", ErrorMissingSMTPParam, entry)
+	}
+
+	return relay, nil
+}
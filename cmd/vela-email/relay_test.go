@@ -0,0 +1,157 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jordan-wright/email"
+)
+
+func TestRelays(t *testing.T) {
+	tests := []struct {
+		name     string
+		hosts    []string
+		port     string
+		sendType string
+		want     []Relay
+		wantErr  error
+	}{
+		{
+			name:     "single host with default port and send type",
+			hosts:    []string{"smtp.example.com"},
+			port:     "587",
+			sendType: "StartTLS",
+			want:     []Relay{{Host: "smtp.example.com", Port: "587", SendType: "StartTLS"}},
+		},
+		{
+			name:     "ordered hosts with their own ports and send types",
+			hosts:    []string{"tls://primary.example.com:465", "secondary.example.com:2525", "plain://[::1]", " "},
+			port:     "25",
+			sendType: "starttls",
+			want: []Relay{
+				{Host: "primary.example.com", Port: "465", SendType: "TLS"},
+				{Host: "secondary.example.com", Port: "2525", SendType: "StartTLS"},
+				{Host: "::1", Port: "25", SendType: "Plain"},
+			},
+		},
+		{
+			name:     "auto send type",
+			hosts:    []string{"smtp.example.com:465", "starttls://backup.example.com"},
+			port:     "587",
+			sendType: "auto",
+			want: []Relay{
+				{Host: "smtp.example.com", Port: "465", SendType: "Auto"},
+				{Host: "backup.example.com", Port: "587", SendType: "StartTLS"},
+			},
+		},
+		{
+			name:     "unknown send type parameter falls back to plain",
+			hosts:    []string{"smtp.example.com:25"},
+			sendType: "carrier-pigeon",
+			want:     []Relay{{Host: "smtp.example.com", Port: "25", SendType: "Plain"}},
+		},
+		{
+			name:    "unknown send type in host",
+			hosts:   []string{"smtps://smtp.example.com:465"},
+			wantErr: ErrorInvalidSMTPHost,
+		},
+		{
+			name:    "host without port",
+			hosts:   []string{"smtp.example.com:587", "backup.example.com"},
+			wantErr: ErrorMissingSMTPParam,
+		},
+		{
+			name:    "no hosts",
+			port:    "587",
+			wantErr: ErrorMissingSMTPParam,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := &SMTPHost{Hosts: test.hosts, Port: test.port}
+
+			got, err := h.relays(test.sendType)
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("relays() error = %v, wantErr = %v", err, test.wantErr)
+			}
+
+			if len(got) != len(test.want) {
+				t.Fatalf("relays() is %v, want %v", got, test.want)
+			}
+
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Errorf("relays()[%d] is %+v, want %+v", i, got[i], test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestExecFailover(t *testing.T) {
+	// reserve a port and close it so connecting is refused
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() should not have raised an error %s", err)
+	}
+
+	down := closed.Addr().String()
+	closed.Close()
+
+	greylisting := newFakeSMTP(t, func(s *fakeSMTP) { s.replies["MAIL"] = "451 4.7.1 greylisted" })
+	accepting := newFakeSMTP(t, nil)
+
+	p := &Plugin{
+		Email: &email.Email{
+			To:      []string{"fakemail1@example.com"},
+			From:    "fakemail2@example.com",
+			Subject: "subject",
+			Text:    []byte("body"),
+		},
+		SMTPHost: &SMTPHost{Hosts: []string{down, greylisting.addr(), "plain://" + accepting.addr()}},
+		SendType: "Plain",
+		Timeouts: &Timeouts{Connect: time.Second, Command: time.Second},
+		BuildEnv: mockBuildEnv,
+	}
+
+	if err := p.Exec(); err != nil {
+		t.Errorf("Exec() should not have raised an error %s", err)
+	}
+
+	if _, messages := greylisting.received(); len(messages) != 0 {
+		t.Errorf("Exec() sent %d messages to the greylisting relay, want 0", len(messages))
+	}
+
+	if _, messages := accepting.received(); len(messages) != 1 {
+		t.Errorf("Exec() sent %d messages to the accepting relay, want 1", len(messages))
+	}
+
+	// every relay failing returns each of their errors
+	p.SMTPHost.Hosts = []string{down, greylisting.addr()}
+
+	err = p.Exec()
+	if err == nil {
+		t.Fatalf("Exec() should have raised an error")
+	}
+
+	if code := replyCode(err); code != 451 {
+		t.Errorf("Exec() error %q should include the 451 reply", err)
+	}
+
+	// a permanent rejection does not fail over
+	rejecting := newFakeSMTP(t, func(s *fakeSMTP) { s.replies["RCPT"] = "550 5.1.1 unknown recipient" })
+	p.SMTPHost.Hosts = []string{rejecting.addr(), accepting.addr()}
+
+	err = p.Exec()
+	if code := replyCode(err); code != 550 {
+		t.Errorf("Exec() error = %v, want the 550 reply", err)
+	}
+
+	if _, messages := accepting.received(); len(messages) != 1 {
+		t.Errorf("Exec() sent %d messages to the accepting relay after a permanent error, want 1", len(messages))
+	}
+}
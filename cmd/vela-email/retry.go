@@ -0,0 +1,171 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/textproto"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Retry represents the configuration for retrying
+// an email that failed to send with a transient error.
+type Retry struct {
+	// Retries is the number of times to retry after the first attempt
+	Retries int
+	// Backoff is the delay before the first retry
+	Backoff time.Duration
+	// MaxBackoff is the maximum delay between retries
+	MaxBackoff time.Duration
+}
+
+// defaultMaxBackoff limits the delay between retries
+// when no maximum backoff is configured.
+const defaultMaxBackoff = 30 * time.Second
+
+// sleep pauses between attempts and is replaced in tests.
+var sleep = time.Sleep
+
+// retry calls send until it succeeds, returns a permanent error or the
+// retries are exhausted. The delay doubles after every attempt up to the
+// maximum backoff with up to half of each delay randomized as jitter.
+func (r *Retry) retry(send func() error) error {
+	retries := 0
+	if r != nil {
+		retries = r.Retries
+	}
+
+	attempts := retries + 1
+
+	var err error
+
+	for attempt := 1; attempt <= attempts; attempt++ {
+		err = send()
+		if err == nil {
+			if attempt > 1 {
+				logrus.Infof("Attempt %d of %d succeeded", attempt, attempts)
+			}
+
+			return nil
+		}
+
+		fields := logrus.Fields{
+			"attempt": fmt.Sprintf("%d/%d", attempt, attempts),
+			"code":    replyCode(err),
+		}
+
+		if !isTransient(err) {
+			logrus.WithFields(fields).Errorf("Attempt failed with a permanent error: %v", err)
+
+			return err
+		}
+
+		if attempt == attempts {
+			logrus.WithFields(fields).Errorf("Attempt failed with a transient error: %v", err)
+
+			break
+		}
+
+		delay := r.delay(attempt)
+
+		logrus.WithFields(fields).Warnf("Attempt failed with a transient error, retrying in %s: %v", delay, err)
+
+		sleep(delay)
+	}
+
+	if attempts > 1 {
+		return fmt.Errorf("giving up after %d attempts: %w", attempts, err)
+	}
+
+	return err
+}
+
+// delay returns the jittered backoff before the next attempt.
+func (r *Retry) delay(attempt int) time.Duration {
+	backoff := r.Backoff
+	if backoff <= 0 {
+		return 0
+	}
+
+	limit := r.MaxBackoff
+	if limit <= 0 {
+		limit = max(backoff, defaultMaxBackoff)
+	}
+
+	// stop doubling at the limit so large attempts cannot overflow
+	for i := 1; i < attempt && backoff < limit; i++ {
+		if backoff > limit/2 {
+			backoff = limit
+
+			break
+		}
+
+		backoff *= 2
+	}
+
+	backoff = min(backoff, limit)
+
+	half := backoff / 2
+
+	//nolint:gosec // jitter does not need a cryptographically secure source
+	return half + rand.N(half+1)
+}
+
+// replyCode returns the SMTP reply code of the error or zero when it has none.
+func replyCode(err error) int {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return protoErr.Code
+	}
+
+	return 0
+}
+
+// isTransient reports whether sending again may succeed. SMTP 4xx
+// replies, timeouts, connection resets and connections that could
+// not be established are transient while 5xx replies are permanent.
+// A sendmail binary exiting with EX_TEMPFAIL and an email provider
+// rate limiting or failing are also transient.
+func isTransient(err error) bool {
+	if code := replyCode(err); code > 0 {
+		return code >= 400 && code < 500
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	if errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+
+	if errors.Is(err, ErrorProviderUnavailable) {
+		return true
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == exTempFail {
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,160 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/textproto"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// timeoutError is a net.Error that reports a timeout.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "greylisted", err: &textproto.Error{Code: 451, Msg: "4.7.1 greylisted, try again later"}, want: true},
+		{name: "mailbox busy", err: fmt.Errorf("error sending with StartTLS: %w", &textproto.Error{Code: 450, Msg: "mailbox busy"}), want: true},
+		{name: "mailbox unavailable", err: &textproto.Error{Code: 550, Msg: "5.1.1 user unknown"}, want: false},
+		{name: "authentication failed", err: &textproto.Error{Code: 535, Msg: "5.7.8 authentication failed"}, want: false},
+		{name: "timeout", err: &net.OpError{Op: "read", Net: "tcp", Err: timeoutError{}}, want: true},
+		{name: "connection reset", err: &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, want: true},
+		{name: "connection refused", err: &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, want: true},
+		{name: "connection closed", err: io.EOF, want: true},
+		{name: "other", err: errors.New("x509: certificate signed by unknown authority"), want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isTransient(test.err); got != test.want {
+				t.Errorf("isTransient() is %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	greylisted := &textproto.Error{Code: 451, Msg: "4.7.1 greylisted"}
+	rejected := &textproto.Error{Code: 550, Msg: "5.1.1 user unknown"}
+
+	tests := []struct {
+		name         string
+		retry        *Retry
+		errs         []error
+		wantAttempts int
+		wantErr      error
+	}{
+		{
+			name:         "success on first attempt",
+			retry:        &Retry{Retries: 3, Backoff: time.Second},
+			errs:         []error{nil},
+			wantAttempts: 1,
+		},
+		{
+			name:         "success after transient errors",
+			retry:        &Retry{Retries: 3, Backoff: time.Second},
+			errs:         []error{greylisted, io.EOF, nil},
+			wantAttempts: 3,
+		},
+		{
+			name:         "permanent error is not retried",
+			retry:        &Retry{Retries: 3, Backoff: time.Second},
+			errs:         []error{greylisted, rejected, nil},
+			wantAttempts: 2,
+			wantErr:      rejected,
+		},
+		{
+			name:         "retries exhausted",
+			retry:        &Retry{Retries: 2, Backoff: time.Second},
+			errs:         []error{greylisted, greylisted, greylisted, nil},
+			wantAttempts: 3,
+			wantErr:      greylisted,
+		},
+		{
+			name:         "retries disabled",
+			retry:        nil,
+			errs:         []error{greylisted, nil},
+			wantAttempts: 1,
+			wantErr:      greylisted,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var delays []time.Duration
+
+			sleep = func(d time.Duration) { delays = append(delays, d) }
+			t.Cleanup(func() { sleep = time.Sleep })
+
+			attempts := 0
+
+			err := test.retry.retry(func() error {
+				err := test.errs[attempts]
+				attempts++
+
+				return err
+			})
+
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("retry() error = %v, wantErr = %v", err, test.wantErr)
+			}
+
+			if attempts != test.wantAttempts {
+				t.Errorf("retry() made %d attempts, want %d", attempts, test.wantAttempts)
+			}
+
+			if len(delays) != attempts-1 {
+				t.Errorf("retry() slept %d times, want %d", len(delays), attempts-1)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	r := &Retry{Backoff: 2 * time.Second, MaxBackoff: 10 * time.Second}
+
+	tests := []struct {
+		retry   *Retry
+		attempt int
+		want    time.Duration
+	}{
+		{retry: r, attempt: 1, want: 2 * time.Second},
+		{retry: r, attempt: 2, want: 4 * time.Second},
+		{retry: r, attempt: 3, want: 8 * time.Second},
+		{retry: r, attempt: 4, want: 10 * time.Second},
+		{retry: r, attempt: 50, want: 10 * time.Second},
+		{retry: &Retry{Backoff: 2 * time.Second}, attempt: 3, want: 8 * time.Second},
+		{retry: &Retry{Backoff: 2 * time.Second}, attempt: 100, want: defaultMaxBackoff},
+		{retry: &Retry{Backoff: time.Minute}, attempt: 100, want: time.Minute},
+		{retry: &Retry{Backoff: time.Second, MaxBackoff: math.MaxInt64}, attempt: 1000, want: math.MaxInt64},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("attempt %d up to %s", test.attempt, test.retry.MaxBackoff), func(t *testing.T) {
+			for range 20 {
+				got := test.retry.delay(test.attempt)
+				if got < test.want/2 || got > test.want {
+					t.Errorf("delay() is %s, want between %s and %s", got, test.want/2, test.want)
+				}
+			}
+		})
+	}
+
+	if got := (&Retry{}).delay(3); got != 0 {
+		t.Errorf("delay() is %s without a backoff, want 0", got)
+	}
+}
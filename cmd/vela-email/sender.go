@@ -0,0 +1,195 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jordan-wright/email"
+	"github.com/sirupsen/logrus"
+)
+
+// Message represents a composed email ready to be delivered.
+type Message struct {
+	// Email is the email the message was composed from
+	Email *email.Email
+	// From is the envelope sender address
+	From string
+	// Recipients are the To, Cc and Bcc addresses
+	Recipients []string
+	// Raw is the composed message, which omits the Bcc header
+	Raw []byte
+}
+
+// Sender represents a transport able to deliver a message.
+type Sender interface {
+	// Send delivers the message or returns why it could not
+	Send(ctx context.Context, msg *Message) error
+}
+
+// message composes the email once so every attempt
+// and transport shares the same Message-Id.
+func (p *Plugin) message() (*Message, error) {
+	from, to, err := envelope(p.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := p.compose()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Message{Email: p.Email, From: from, Recipients: to, Raw: raw}, nil
+}
+
+// compose builds the message from the email, applying S/MIME or OpenPGP
+// and then DKIM when configured, so every transport and the dry run match.
+func (p *Plugin) compose() ([]byte, error) {
+	raw, err := p.Email.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.SMIME.enabled() {
+		_, recipients, err := envelope(p.Email)
+		if err != nil {
+			return nil, err
+		}
+
+		if raw, err = p.SMIME.apply(raw, recipients); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.PGP.enabled() {
+		_, recipients, err := envelope(p.Email)
+		if err != nil {
+			return nil, err
+		}
+
+		if raw, err = p.PGP.apply(raw, recipients); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.DKIM.enabled() {
+		return p.DKIM.sign(raw, time.Now())
+	}
+
+	return raw, nil
+}
+
+// sender returns the transport for the send type.
+func (p *Plugin) sender() (Sender, error) {
+	logrus.Trace("entered plugin.sender")
+	defer logrus.Trace("exited plugin.sender")
+
+	if p.useSendmail() {
+		return &retrySender{Sender: &sendmailSender{p: p}, retry: p.Retry}, nil
+	}
+
+	if p.useFile() {
+		return &fileSender{dir: p.FilePath, maildir: strings.EqualFold(p.FileFormat, "maildir")}, nil
+	}
+
+	if provider, ok := providers[strings.ToLower(p.SendType)]; ok {
+		if provider == "SendGrid" && p.DKIM.enabled() {
+			logrus.Warn("SendGrid signs emails with its own domain authentication, the DKIM parameters are not used")
+		}
+
+		sender, err := p.API.sender(provider, p.httpClient())
+		if err != nil {
+			return nil, err
+		}
+
+		return &retrySender{Sender: sender, retry: p.Retry}, nil
+	}
+
+	relays, err := p.SMTPHost.relays(p.SendType)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.fetchToken(); err != nil {
+		return nil, err
+	}
+
+	return &smtpSender{p: p, relays: relays}, nil
+}
+
+// httpClient returns the client used for HTTP requests, limited
+// by the total timeout and using the TLS configuration.
+func (p *Plugin) httpClient() *http.Client {
+	client := &http.Client{Timeout: time.Minute}
+	if p.Timeouts != nil && p.Timeouts.Total > 0 {
+		client.Timeout = p.Timeouts.Total
+	}
+
+	if p.TLSConfig != nil {
+		transport, _ := http.DefaultTransport.(*http.Transport)
+		transport = transport.Clone()
+		transport.TLSClientConfig = p.TLSConfig.Clone()
+
+		client.Transport = transport
+	}
+
+	return client
+}
+
+// retrySender retries the sender when it fails with a transient error.
+type retrySender struct {
+	Sender
+	retry *Retry
+}
+
+// Send delivers the message, retrying transient failures.
+func (s *retrySender) Send(ctx context.Context, msg *Message) error {
+	return s.retry.retry(func() error { return s.Sender.Send(ctx, msg) })
+}
+
+// smtpSender delivers messages through the ordered list of
+// relays, failing over to the next relay once one gives up.
+type smtpSender struct {
+	p      *Plugin
+	relays []Relay
+}
+
+// Send delivers the message through the first relay accepting it,
+// only failing over to the next relay after a transient error.
+func (s *smtpSender) Send(ctx context.Context, msg *Message) error {
+	var errs []error
+
+	for i, relay := range s.relays {
+		if i > 0 {
+			logrus.Warnf("Failing over to %s (%d of %d)...", relay, i+1, len(s.relays))
+		}
+
+		err := s.p.Retry.retry(func() error { return s.p.send(ctx, relay, msg) })
+		if err == nil {
+			logrus.Infof("Email accepted by %s", relay)
+
+			return nil
+		}
+
+		if len(s.relays) == 1 {
+			return err
+		}
+
+		// a permanent rejection would be repeated by the other relays
+		if !isTransient(err) {
+			return fmt.Errorf("%s rejected the email, not failing over: %w", relay, err)
+		}
+
+		logrus.Errorf("Unable to send email through %s: %v", relay, err)
+
+		errs = append(errs, fmt.Errorf("%s: %w", relay, err))
+	}
+
+	return fmt.Errorf("unable to send email through any of the %d smtp hosts: %w", len(s.relays), errors.Join(errs...))
+}
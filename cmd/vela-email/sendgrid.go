@@ -0,0 +1,204 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// sendGridReservedHeaders are set by SendGrid and rejected as custom headers.
+var sendGridReservedHeaders = map[string]bool{
+	"Bcc":                       true,
+	"Cc":                        true,
+	"Content-Transfer-Encoding": true,
+	"Content-Type":              true,
+	"Dkim-Signature":            true,
+	"From":                      true,
+	"Received":                  true,
+	"Reply-To":                  true,
+	"Subject":                   true,
+	"To":                        true,
+	"X-Sg-Eid":                  true,
+	"X-Sg-Id":                   true,
+}
+
+type (
+	// sendGrid sends messages through the SendGrid v3 mail send
+	// API, which accepts the email as JSON instead of MIME.
+	sendGrid struct {
+		api    *API
+		client *http.Client
+	}
+
+	sendGridAddress struct {
+		Email string `json:"email"`
+		Name  string `json:"name,omitempty"`
+	}
+
+	sendGridPersonalization struct {
+		To  []sendGridAddress `json:"to"`
+		Cc  []sendGridAddress `json:"cc,omitempty"`
+		Bcc []sendGridAddress `json:"bcc,omitempty"`
+	}
+
+	sendGridContent struct {
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	}
+
+	sendGridAttachment struct {
+		Content     string `json:"content"`
+		Type        string `json:"type,omitempty"`
+		Filename    string `json:"filename"`
+		Disposition string `json:"disposition,omitempty"`
+		ContentID   string `json:"content_id,omitempty"`
+	}
+
+	sendGridRequest struct {
+		Personalizations []sendGridPersonalization `json:"personalizations"`
+		From             sendGridAddress           `json:"from"`
+		ReplyTo          *sendGridAddress          `json:"reply_to,omitempty"`
+		ReplyToList      []sendGridAddress         `json:"reply_to_list,omitempty"`
+		Subject          string                    `json:"subject"`
+		Content          []sendGridContent         `json:"content"`
+		Attachments      []sendGridAttachment      `json:"attachments,omitempty"`
+		Headers          map[string]string         `json:"headers,omitempty"`
+	}
+)
+
+// Send posts the email to the mail send API.
+func (s *sendGrid) Send(ctx context.Context, msg *Message) error {
+	logrus.Trace("entered plugin.sendGrid")
+	defer logrus.Trace("exited plugin.sendGrid")
+
+	payload, err := sendGridPayload(msg)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.api.endpoint("https://api.sendgrid.com")+"/v3/mail/send", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+s.api.Key)
+	req.Header.Set("Content-Type", "application/json")
+
+	logrus.Info("Sending email with SendGrid...")
+
+	if _, err := do(s.client, "SendGrid", req); err != nil {
+		return err
+	}
+
+	logrus.Info("Email accepted by SendGrid")
+
+	return nil
+}
+
+// sendGridPayload converts the email into the mail send request.
+func sendGridPayload(msg *Message) (*sendGridRequest, error) {
+	e := msg.Email
+
+	from, err := sendGridAddressOf(e.From)
+	if err != nil {
+		return nil, err
+	}
+
+	var p sendGridPersonalization
+
+	for _, list := range []struct {
+		addrs []string
+		dst   *[]sendGridAddress
+	}{{e.To, &p.To}, {e.Cc, &p.Cc}, {e.Bcc, &p.Bcc}} {
+		for _, entry := range list.addrs {
+			addr, err := sendGridAddressOf(entry)
+			if err != nil {
+				return nil, err
+			}
+
+			*list.dst = append(*list.dst, addr)
+		}
+	}
+
+	req := &sendGridRequest{
+		Personalizations: []sendGridPersonalization{p},
+		From:             from,
+		Subject:          e.Subject,
+	}
+
+	for _, entry := range e.ReplyTo {
+		addr, err := sendGridAddressOf(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		req.ReplyToList = append(req.ReplyToList, addr)
+	}
+
+	// a single reply to address cannot be sent as a list
+	if len(req.ReplyToList) == 1 {
+		req.ReplyTo, req.ReplyToList = &req.ReplyToList[0], nil
+	}
+
+	// the plain text must come before the HTML
+	if len(e.Text) > 0 {
+		req.Content = append(req.Content, sendGridContent{Type: "text/plain", Value: string(e.Text)})
+	}
+
+	if len(e.HTML) > 0 {
+		req.Content = append(req.Content, sendGridContent{Type: "text/html", Value: string(e.HTML)})
+	}
+
+	for _, a := range e.Attachments {
+		attachment := sendGridAttachment{
+			Content:  base64.StdEncoding.EncodeToString(a.Content),
+			Type:     a.ContentType,
+			Filename: a.Filename,
+		}
+
+		if a.HTMLRelated {
+			attachment.Disposition = "inline"
+			attachment.ContentID = strings.Trim(a.Header.Get("Content-ID"), "<>")
+		}
+
+		req.Attachments = append(req.Attachments, attachment)
+	}
+
+	for name, values := range e.Headers {
+		if sendGridReservedHeaders[name] || len(values) == 0 {
+			continue
+		}
+
+		if req.Headers == nil {
+			req.Headers = map[string]string{}
+		}
+
+		req.Headers[name] = strings.Join(values, ", ")
+	}
+
+	return req, nil
+}
+
+// sendGridAddressOf parses the address with its display name.
+func sendGridAddressOf(entry string) (sendGridAddress, error) {
+	addr, err := mail.ParseAddress(entry)
+	if err != nil {
+		return sendGridAddress{}, fmt.Errorf("invalid address %s: %w", entry, err)
+	}
+
+	return sendGridAddress{Email: addr.Address, Name: addr.Name}, nil
+}
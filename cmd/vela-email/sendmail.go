@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/mail"
+	"os/exec"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// exTempFail is the exit status sendmail compatible binaries
+// use when the email could not be sent yet and may be retried.
+const exTempFail = 75
+
+// useSendmail reports whether the email is sent through
+// the sendmail binary instead of an SMTP relay.
+func (p *Plugin) useSendmail() bool {
+	return strings.EqualFold(p.SendType, "sendmail")
+}
+
+// sendmailSender delivers messages through the sendmail binary.
+type sendmailSender struct {
+	p *Plugin
+}
+
+// Send pipes the message into the sendmail binary with -t -i
+// semantics, reporting its exit status and stderr on failure.
+func (s *sendmailSender) Send(ctx context.Context, msg *Message) error {
+	logrus.Trace("entered plugin.sendmail")
+	defer logrus.Trace("exited plugin.sendmail")
+
+	// the binary reads the recipients from the headers and removes the Bcc header
+	raw, err := withBcc(msg.Raw, msg.Email.Bcc)
+	if err != nil {
+		return err
+	}
+
+	path, timeouts := s.p.SendmailPath, s.p.Timeouts
+
+	if timeouts != nil && timeouts.Total > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, timeouts.Total)
+		defer cancel()
+	}
+
+	var stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, path, "-t", "-i", "-f", msg.From)
+	cmd.Stdin = bytes.NewReader(raw)
+	cmd.Stderr = &stderr
+
+	logrus.Infof("Sending email with %s...", path)
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil && timeouts != nil {
+			return fmt.Errorf("%w: %s timed out, total timeout of %s reached: %w", ErrorSendmail, path, timeouts.Total, ctx.Err())
+		}
+
+		if out := strings.TrimSpace(stderr.String()); len(out) > 0 {
+			return fmt.Errorf("%w: %s: %w: %s", ErrorSendmail, path, err, out)
+		}
+
+		return fmt.Errorf("%w: %s: %w", ErrorSendmail, path, err)
+	}
+
+	logrus.Infof("Email accepted by %s", path)
+
+	return nil
+}
+
+// withBcc adds the Bcc header omitted from the composed
+// message so the blind copies are still delivered.
+func withBcc(msg []byte, bcc []string) ([]byte, error) {
+	if len(bcc) == 0 {
+		return msg, nil
+	}
+
+	addrs := make([]string, 0, len(bcc))
+
+	for _, recipient := range bcc {
+		addr, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient %s: %w", recipient, err)
+		}
+
+		addrs = append(addrs, addr.String())
+	}
+
+	header := "Bcc: " + strings.Join(addrs, ",\r\n ") + "\r\n"
+
+	return append([]byte(header), msg...), nil
+}
@@ -0,0 +1,140 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jordan-wright/email"
+)
+
+// writeSendmail writes a stub sendmail script recording its arguments
+// and message to the directory before running the script body.
+func writeSendmail(t *testing.T, dir, body string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, "sendmail")
+	script := "#!/bin/sh\n" +
+		"echo \"$@\" >> " + filepath.Join(dir, "args") + "\n" +
+		"cat > " + filepath.Join(dir, "message") + "\n" +
+		body + "\n"
+
+	if err := os.WriteFile(path, []byte(script), 0o700); err != nil {
+		t.Fatalf("WriteFile() should not have raised an error %s", err)
+	}
+
+	return path
+}
+
+func TestExecSendmail(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		wantAttempts int
+		wantErr      string
+	}{
+		{
+			name:         "accepted",
+			body:         "exit 0",
+			wantAttempts: 1,
+		},
+		{
+			name:         "rejected",
+			body:         "echo 'sendmail: recipient address rejected' >&2\nexit 1",
+			wantAttempts: 1,
+			wantErr:      "exit status 1: sendmail: recipient address rejected",
+		},
+		{
+			name:         "temporary failure is retried",
+			body:         "if [ ! -f \"$0.failed\" ]; then touch \"$0.failed\"; echo 'deferred' >&2; exit 75; fi",
+			wantAttempts: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			p := &Plugin{
+				Email: &email.Email{
+					To:      []string{"fakemail1@example.com"},
+					Bcc:     []string{"Hidden <hidden@example.com>", "other@example.com"},
+					From:    "fakemail2@example.com",
+					Subject: "subject",
+					Text:    []byte("body"),
+				},
+				SendType:     "sendmail",
+				SendmailPath: writeSendmail(t, dir, test.body),
+				Retry:        &Retry{Retries: 1},
+				BuildEnv:     mockBuildEnv,
+			}
+
+			if err := p.Validate(); err != nil {
+				t.Errorf("Validate() should not have raised an error %s", err)
+			}
+
+			err := p.Exec()
+			if len(test.wantErr) > 0 {
+				if !errors.Is(err, ErrorSendmail) || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("Exec() error is %v, want it to contain %q", err, test.wantErr)
+				}
+			} else if err != nil {
+				t.Errorf("Exec() should not have raised an error %s", err)
+			}
+
+			args, err := os.ReadFile(filepath.Join(dir, "args"))
+			if err != nil {
+				t.Fatalf("ReadFile() should not have raised an error %s", err)
+			}
+
+			want := strings.Repeat("-t -i -f fakemail2@example.com\n", test.wantAttempts)
+			if string(args) != want {
+				t.Errorf("Exec() arguments are %q, want %q", args, want)
+			}
+
+			msg, err := os.ReadFile(filepath.Join(dir, "message"))
+			if err != nil {
+				t.Fatalf("ReadFile() should not have raised an error %s", err)
+			}
+
+			if !strings.HasPrefix(string(msg), "Bcc: \"Hidden\" <hidden@example.com>,\r\n <other@example.com>\r\n") || !strings.Contains(string(msg), "Subject: subject") {
+				t.Errorf("Exec() message is %q, want the Bcc header added", msg)
+			}
+		})
+	}
+}
+
+func TestExecSendmailTimeout(t *testing.T) {
+	p := &Plugin{
+		Email: &email.Email{
+			To:   []string{"fakemail1@example.com"},
+			From: "fakemail2@example.com",
+			Text: []byte("body"),
+		},
+		SendType:     "Sendmail",
+		SendmailPath: writeSendmail(t, t.TempDir(), "exec sleep 5"),
+		Timeouts:     &Timeouts{Total: 100 * time.Millisecond},
+		BuildEnv:     mockBuildEnv,
+	}
+
+	err := p.Exec()
+	if !errors.Is(err, ErrorSendmail) || !strings.Contains(err.Error(), "total timeout of 100ms reached") {
+		t.Errorf("Exec() error is %v, want the total timeout reached", err)
+	}
+}
+
+func TestValidateSendmail(t *testing.T) {
+	p := &Plugin{
+		Email:        &email.Email{To: []string{"fakemail1@example.com"}, From: "fakemail2@example.com"},
+		SendType:     "Sendmail",
+		SendmailPath: filepath.Join(t.TempDir(), "missing"),
+	}
+
+	if err := p.Validate(); !errors.Is(err, ErrorSendmail) {
+		t.Errorf("Validate() error = %v, wantErr = %v", err, ErrorSendmail)
+	}
+}
@@ -0,0 +1,250 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	// ses sends messages through the Amazon SES v2 API, which
+	// delivers the composed message to the destination as is.
+	ses struct {
+		api    *API
+		client *http.Client
+	}
+
+	sesDestination struct {
+		ToAddresses  []string `json:"ToAddresses,omitempty"`
+		CcAddresses  []string `json:"CcAddresses,omitempty"`
+		BccAddresses []string `json:"BccAddresses,omitempty"`
+	}
+
+	sesRequest struct {
+		FromEmailAddress string         `json:"FromEmailAddress"`
+		Destination      sesDestination `json:"Destination"`
+		Content          struct {
+			Raw struct {
+				Data []byte `json:"Data"`
+			} `json:"Raw"`
+		} `json:"Content"`
+	}
+)
+
+// Send posts the composed message signed with the AWS credentials.
+func (s *ses) Send(ctx context.Context, msg *Message) error {
+	logrus.Trace("entered plugin.ses")
+	defer logrus.Trace("exited plugin.ses")
+
+	payload := sesRequest{FromEmailAddress: msg.From}
+	payload.Content.Raw.Data = msg.Raw
+
+	var err error
+
+	// the destination includes the Bcc addresses omitted from the message
+	for _, list := range []struct {
+		addrs []string
+		dst   *[]string
+	}{
+		{msg.Email.To, &payload.Destination.ToAddresses},
+		{msg.Email.Cc, &payload.Destination.CcAddresses},
+		{msg.Email.Bcc, &payload.Destination.BccAddresses},
+	} {
+		if *list.dst, err = addresses(list.addrs); err != nil {
+			return err
+		}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	endpoint := s.api.endpoint(fmt.Sprintf("https://email.%s.amazonaws.com", s.api.Region)) + "/v2/email/outbound-emails"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	signV4(req, body, s.api, "ses", time.Now())
+
+	logrus.Infof("Sending email with SES in %s...", s.api.Region)
+
+	resp, err := do(s.client, "SES", req)
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		MessageID string `json:"MessageId"`
+	}
+
+	if err := json.Unmarshal(resp, &result); err == nil && len(result.MessageID) > 0 {
+		logrus.Debugf("SES accepted the email as %s", result.MessageID)
+	}
+
+	logrus.Info("Email accepted by SES")
+
+	return nil
+}
+
+// signV4 signs the request for the service with AWS Signature
+// Version 4, using the credentials and region of the API.
+func signV4(req *http.Request, body []byte, a *API, service string, t time.Time) {
+	t = t.UTC()
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	if len(a.SessionToken) > 0 {
+		req.Header.Set("X-Amz-Security-Token", a.SessionToken)
+	}
+
+	host := req.Host
+	if len(host) == 0 {
+		host = req.URL.Host
+	}
+
+	headers := map[string]string{"host": host}
+
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name != "content-type" && !strings.HasPrefix(name, "x-amz-") {
+			continue
+		}
+
+		trimmed := make([]string, 0, len(values))
+		for _, value := range values {
+			trimmed = append(trimmed, strings.Join(strings.Fields(value), " "))
+		}
+
+		headers[name] = strings.Join(trimmed, ",")
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalPath(req.URL.EscapedPath()),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hashHex(body),
+	}, "\n")
+
+	scope := date + "/" + a.Region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hashHex([]byte(canonicalRequest))
+
+	key := []byte("AWS4" + a.SecretAccessKey)
+	for _, part := range []string{date, a.Region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", a.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// canonicalPath encodes every segment of the escaped path again,
+// as required for every service other than S3.
+func canonicalPath(path string) string {
+	if len(path) == 0 {
+		return "/"
+	}
+
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = uriEncode(segment)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// canonicalQuery encodes the query parameters sorted by name and value.
+func canonicalQuery(query map[string][]string) string {
+	encoded := map[string][]string{}
+	names := make([]string, 0, len(query))
+
+	for name, values := range query {
+		name = uriEncode(name)
+		names = append(names, name)
+
+		for _, value := range values {
+			encoded[name] = append(encoded[name], uriEncode(value))
+		}
+
+		sort.Strings(encoded[name])
+	}
+
+	sort.Strings(names)
+
+	var params []string
+
+	for _, name := range names {
+		for _, value := range encoded[name] {
+			params = append(params, name+"="+value)
+		}
+	}
+
+	return strings.Join(params, "&")
+}
+
+// uriEncode percent encodes every byte other than the unreserved characters.
+func uriEncode(s string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+// hashHex returns the hex encoded SHA-256 hash of the data.
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of the data with the key.
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,336 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/smallstep/pkcs7"
+)
+
+func init() {
+	// the pkcs7 package encrypts with DES unless configured otherwise
+	pkcs7.ContentEncryptionAlgorithm = pkcs7.EncryptionAlgorithmAES256CBC
+}
+
+// SMIME represents the configuration for signing
+// and encrypting emails with S/MIME.
+type SMIME struct {
+	// Cert is the signing certificate file or PEM, followed by its chain
+	Cert string
+	// Key is the private key file or PEM of the signing certificate
+	Key string
+	// Recipients is a directory or bundle of recipient certificates used for encryption
+	Recipients string
+
+	certificate *tls.Certificate
+	chain       []*x509.Certificate
+	recipients  []*x509.Certificate
+}
+
+// signing reports whether emails are signed.
+func (s *SMIME) signing() bool {
+	return s != nil && (len(s.Cert) > 0 || len(s.Key) > 0)
+}
+
+// encrypting reports whether emails are encrypted.
+func (s *SMIME) encrypting() bool {
+	return s != nil && len(s.Recipients) > 0
+}
+
+// enabled reports whether S/MIME is configured.
+func (s *SMIME) enabled() bool {
+	return s.signing() || s.encrypting()
+}
+
+// validate loads the signing certificate and recipient certificates.
+func (s *SMIME) validate() error {
+	if s.signing() {
+		if err := s.loadCertificate(); err != nil {
+			return err
+		}
+	}
+
+	if s.encrypting() {
+		if err := s.loadRecipients(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadCertificate parses the signing certificate, its chain and key.
+func (s *SMIME) loadCertificate() error {
+	if s.certificate != nil {
+		return nil
+	}
+
+	if len(s.Cert) == 0 || len(s.Key) == 0 {
+		return fmt.Errorf("%w: both smime_cert and smime_key are required to sign", ErrorInvalidSMIMEParam)
+	}
+
+	certPEM, err := readPEM(s.Cert)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrorInvalidSMIMEParam, err)
+	}
+
+	keyPEM, err := readPEM(s.Key)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrorInvalidSMIMEParam, err)
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrorInvalidSMIMEParam, err)
+	}
+
+	if time.Now().After(cert.Leaf.NotAfter) {
+		return fmt.Errorf("%w: signing certificate for %s expired on %s", ErrorInvalidSMIMEParam, cert.Leaf.Subject, cert.Leaf.NotAfter)
+	}
+
+	var chain []*x509.Certificate
+
+	for _, der := range cert.Certificate[1:] {
+		parent, err := x509.ParseCertificate(der)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrorInvalidSMIMEParam, err)
+		}
+
+		chain = append(chain, parent)
+	}
+
+	s.certificate, s.chain = &cert, chain
+
+	return nil
+}
+
+// loadRecipients parses the recipient certificates from the PEM
+// bundle or from every file in the directory.
+func (s *SMIME) loadRecipients() error {
+	if s.recipients != nil {
+		return nil
+	}
+
+	var files [][]byte
+
+	info, err := os.Stat(s.Recipients)
+
+	switch {
+	case strings.Contains(s.Recipients, "-----BEGIN"):
+		files = append(files, []byte(s.Recipients))
+	case err != nil:
+		return fmt.Errorf("%w: %w", ErrorInvalidSMIMEParam, err)
+	case info.IsDir():
+		entries, err := os.ReadDir(s.Recipients)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrorInvalidSMIMEParam, err)
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+
+			data, err := os.ReadFile(filepath.Join(s.Recipients, entry.Name()))
+			if err != nil {
+				return fmt.Errorf("%w: %w", ErrorInvalidSMIMEParam, err)
+			}
+
+			files = append(files, data)
+		}
+	default:
+		data, err := os.ReadFile(s.Recipients)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrorInvalidSMIMEParam, err)
+		}
+
+		files = append(files, data)
+	}
+
+	var certs []*x509.Certificate
+
+	for _, data := range files {
+		parsed, err := parseCertificates(data)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrorInvalidSMIMEParam, err)
+		}
+
+		certs = append(certs, parsed...)
+	}
+
+	if len(certs) == 0 {
+		return fmt.Errorf("%w: no recipient certificates found in %s", ErrorInvalidSMIMEParam, s.Recipients)
+	}
+
+	s.recipients = certs
+
+	return nil
+}
+
+// parseCertificates parses the PEM certificates, or a single
+// DER certificate when the data does not contain PEM.
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		certs = append(certs, cert)
+	}
+
+	if len(certs) > 0 || bytes.Contains(data, []byte("-----BEGIN")) {
+		return certs, nil
+	}
+
+	cert, err := x509.ParseCertificate(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*x509.Certificate{cert}, nil
+}
+
+// apply signs and then encrypts the MIME entity of the message,
+// keeping the other headers outside of the new entity.
+func (s *SMIME) apply(msg []byte, recipients []string) ([]byte, error) {
+	logrus.Trace("entered plugin.smime.apply")
+	defer logrus.Trace("exited plugin.smime.apply")
+
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+
+	outer, entity := splitEntity(msg)
+
+	var err error
+
+	if s.signing() {
+		entity, err = s.sign(entity)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if s.encrypting() {
+		entity, err = s.encrypt(entity, recipients)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return append([]byte(outer), entity...), nil
+}
+
+// sign returns a multipart/signed entity with the
+// entity and its detached SHA-256 signature.
+func (s *SMIME) sign(entity []byte) ([]byte, error) {
+	sd, err := pkcs7.NewSignedData(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	sd.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+
+	if err := sd.AddSignerChain(s.certificate.Leaf, s.certificate.PrivateKey, s.chain, pkcs7.SignerInfoConfig{}); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrorInvalidSMIMEParam, err)
+	}
+
+	sd.Detach()
+
+	signature, err := sd.Finish()
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+
+	b.WriteString("Content-Type: application/pkcs7-signature; name=\"smime.p7s\"\r\n")
+	b.WriteString("Content-Transfer-Encoding: base64\r\n")
+	b.WriteString("Content-Disposition: attachment; filename=\"smime.p7s\"\r\n\r\n")
+	b.Write(base64Lines(signature))
+
+	logrus.Debugf("Signed email with S/MIME as %s", s.certificate.Leaf.Subject)
+
+	return multipartEntity(
+		`multipart/signed; protocol="application/pkcs7-signature"; micalg=sha-256`,
+		"This is a cryptographically signed message in MIME format.",
+		entity, b.Bytes(),
+	), nil
+}
+
+// encrypt returns an application/pkcs7-mime entity with the entity
+// encrypted for the recipients and the sender when signing.
+func (s *SMIME) encrypt(entity []byte, recipients []string) ([]byte, error) {
+	var certs []*x509.Certificate
+
+	for _, recipient := range recipients {
+		cert := s.recipientCertificate(recipient)
+		if cert == nil {
+			return nil, fmt.Errorf("%w: no valid RSA certificate for recipient %s", ErrorInvalidSMIMEParam, recipient)
+		}
+
+		if !slices.Contains(certs, cert) {
+			certs = append(certs, cert)
+		}
+	}
+
+	// only an RSA signing certificate can also be used for key transport to the sender
+	if s.signing() {
+		if _, ok := s.certificate.Leaf.PublicKey.(*rsa.PublicKey); ok {
+			certs = append(certs, s.certificate.Leaf)
+		}
+	}
+
+	encrypted, err := pkcs7.Encrypt(entity, certs)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+
+	b.WriteString("Content-Type: application/pkcs7-mime; smime-type=enveloped-data; name=\"smime.p7m\"\r\n")
+	b.WriteString("Content-Transfer-Encoding: base64\r\n")
+	b.WriteString("Content-Disposition: attachment; filename=\"smime.p7m\"\r\n\r\n")
+	b.Write(base64Lines(encrypted))
+
+	logrus.Debugf("Encrypted email with S/MIME for %d certificates", len(certs))
+
+	return b.Bytes(), nil
+}
+
+// recipientCertificate returns the unexpired RSA certificate
+// for the address, as only RSA key transport is supported.
+func (s *SMIME) recipientCertificate(addr string) *x509.Certificate {
+	now := time.Now()
+
+	for _, cert := range s.recipients {
+		if _, ok := cert.PublicKey.(*rsa.PublicKey); !ok || now.After(cert.NotAfter) || now.Before(cert.NotBefore) {
+			continue
+		}
+
+		if slices.ContainsFunc(cert.EmailAddresses, func(email string) bool { return strings.EqualFold(email, addr) }) {
+			return cert
+		}
+	}
+
+	return nil
+}
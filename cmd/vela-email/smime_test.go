@@ -0,0 +1,353 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jordan-wright/email"
+	"github.com/smallstep/pkcs7"
+)
+
+// smimeCertificate returns a PEM encoded RSA certificate
+// and key for the email address valid until notAfter.
+func smimeCertificate(t *testing.T, addr string, notAfter time.Time) (string, string) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey() should not have raised an error %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(time.Now().UnixNano()),
+		Subject:        pkix.Name{CommonName: addr},
+		EmailAddresses: []string{addr},
+		NotBefore:      notAfter.Add(-2 * time.Hour),
+		NotAfter:       notAfter,
+		KeyUsage:       x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate() should not have raised an error %s", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	return string(certPEM), string(keyPEM)
+}
+
+// decodeEntity returns the decoded body of the base64 encoded entity.
+func decodeEntity(t *testing.T, entity []byte) []byte {
+	t.Helper()
+
+	_, body := splitMessage(entity)
+
+	der, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(body), "\r\n", ""))
+	if err != nil {
+		t.Fatalf("DecodeString() should not have raised an error %s", err)
+	}
+
+	return der
+}
+
+// multipartParts returns the Content-Type header and the
+// parts of the multipart entity exactly as they were written.
+func multipartParts(t *testing.T, entity []byte) (string, []string) {
+	t.Helper()
+
+	fields, body := splitMessage(entity)
+	if len(fields) == 0 || !strings.Contains(fields[0].raw, "multipart/") {
+		t.Fatalf("entity %q is not multipart", entity)
+	}
+
+	_, after, _ := strings.Cut(fields[0].raw, `boundary="`)
+	boundary, _, _ := strings.Cut(after, `"`)
+
+	parts := strings.Split(string(body), "\r\n--"+boundary)
+	if len(parts) < 2 || !strings.HasPrefix(parts[len(parts)-1], "--") {
+		t.Fatalf("entity %q is not terminated by its boundary", entity)
+	}
+
+	parts = parts[1 : len(parts)-1]
+	for i := range parts {
+		parts[i] = strings.TrimPrefix(parts[i], "\r\n")
+	}
+
+	return fields[0].raw, parts
+}
+
+// verifySMIME verifies the multipart/signed entity
+// and returns the signed entity.
+func verifySMIME(t *testing.T, entity []byte) []byte {
+	t.Helper()
+
+	contentType, parts := multipartParts(t, entity)
+	if !strings.Contains(contentType, "multipart/signed") || len(parts) != 2 {
+		t.Fatalf("entity %q is not multipart/signed with a signature", entity)
+	}
+
+	p7, err := pkcs7.Parse(decodeEntity(t, []byte(parts[1])))
+	if err != nil {
+		t.Fatalf("Parse() should not have raised an error %s", err)
+	}
+
+	p7.Content = []byte(parts[0])
+
+	if err := p7.Verify(); err != nil {
+		t.Errorf("Verify() should not have raised an error %s", err)
+	}
+
+	return []byte(parts[0])
+}
+
+func TestSplitEntity(t *testing.T) {
+	msg := "From: a@example.com\r\nContent-Type: text/plain;\r\n charset=UTF-8\r\nSubject: subject\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\nMIME-Version: 1.0\r\n\r\nbody\r\n"
+
+	outer, entity := splitEntity([]byte(msg))
+
+	if want := "From: a@example.com\r\nSubject: subject\r\nMIME-Version: 1.0\r\n"; outer != want {
+		t.Errorf("splitEntity() outer is %q, want %q", outer, want)
+	}
+
+	if want := "Content-Type: text/plain;\r\n charset=UTF-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nbody\r\n"; string(entity) != want {
+		t.Errorf("splitEntity() entity is %q, want %q", entity, want)
+	}
+}
+
+func TestExecSMIME(t *testing.T) {
+	certPEM, keyPEM := smimeCertificate(t, "fakemail2@example.com", time.Now().Add(time.Hour))
+	recipientPEM, recipientKeyPEM := smimeCertificate(t, "fakemail1@example.com", time.Now().Add(time.Hour))
+	expiredPEM, _ := smimeCertificate(t, "fakemail3@example.com", time.Now().Add(-time.Hour))
+
+	dir := t.TempDir()
+	recipients := filepath.Join(dir, "recipients")
+
+	if err := os.Mkdir(recipients, 0o700); err != nil {
+		t.Fatalf("Mkdir() should not have raised an error %s", err)
+	}
+
+	for name, cert := range map[string]string{"fakemail1.pem": recipientPEM, "fakemail3.pem": expiredPEM} {
+		if err := os.WriteFile(filepath.Join(recipients, name), []byte(cert), 0o600); err != nil {
+			t.Fatalf("WriteFile() should not have raised an error %s", err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		smime     *SMIME
+		to        []string
+		signed    bool
+		encrypted bool
+		wantErr   error
+	}{
+		{
+			name:   "sign",
+			smime:  &SMIME{Cert: certPEM, Key: keyPEM},
+			to:     []string{"fakemail1@example.com"},
+			signed: true,
+		},
+		{
+			name:      "encrypt",
+			smime:     &SMIME{Recipients: recipients},
+			to:        []string{"Fake Mail <FAKEMAIL1@example.com>"},
+			encrypted: true,
+		},
+		{
+			name:      "sign and encrypt",
+			smime:     &SMIME{Cert: certPEM, Key: keyPEM, Recipients: recipientPEM},
+			to:        []string{"fakemail1@example.com"},
+			signed:    true,
+			encrypted: true,
+		},
+		{
+			name:    "recipient with expired certificate",
+			smime:   &SMIME{Recipients: recipients},
+			to:      []string{"fakemail1@example.com", "fakemail3@example.com"},
+			wantErr: ErrorInvalidSMIMEParam,
+		},
+		{
+			name:    "recipient without certificate",
+			smime:   &SMIME{Recipients: recipients},
+			to:      []string{"fakemail4@example.com"},
+			wantErr: ErrorInvalidSMIMEParam,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			outbox := t.TempDir()
+
+			p := &Plugin{
+				Email: &email.Email{
+					To:      test.to,
+					From:    "fakemail2@example.com",
+					Subject: "subject",
+					Text:    []byte("body"),
+					HTML:    []byte("<p>body</p>"),
+				},
+				SMIME:    test.smime,
+				SendType: "File",
+				FilePath: outbox,
+				BuildEnv: mockBuildEnv,
+			}
+
+			if err := p.Validate(); err != nil {
+				t.Fatalf("Validate() should not have raised an error %s", err)
+			}
+
+			err := p.Exec()
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("Exec() error = %v, wantErr = %v", err, test.wantErr)
+			}
+
+			if test.wantErr != nil {
+				return
+			}
+
+			files, _ := filepath.Glob(filepath.Join(outbox, "*.eml"))
+			if len(files) != 1 {
+				t.Fatalf("Exec() wrote %q, want one message", files)
+			}
+
+			msg, err := os.ReadFile(files[0])
+			if err != nil {
+				t.Fatalf("ReadFile() should not have raised an error %s", err)
+			}
+
+			outer, entity := splitEntity(msg)
+			if !strings.Contains(outer, "Subject: subject\r\n") || !strings.Contains(outer, "Mime-Version: 1.0\r\n") {
+				t.Errorf("Exec() wrote headers %q, want the headers kept outside the entity", outer)
+			}
+
+			if test.encrypted {
+				if !strings.HasPrefix(string(entity), "Content-Type: application/pkcs7-mime; smime-type=enveloped-data") {
+					t.Fatalf("Exec() wrote entity %q, want application/pkcs7-mime", entity)
+				}
+
+				p7, err := pkcs7.Parse(decodeEntity(t, entity))
+				if err != nil {
+					t.Fatalf("Parse() should not have raised an error %s", err)
+				}
+
+				cert, _ := parseCertificates([]byte(recipientPEM))
+				block, _ := pem.Decode([]byte(recipientKeyPEM))
+				key, _ := x509.ParsePKCS1PrivateKey(block.Bytes)
+
+				entity, err = p7.Decrypt(cert[0], key)
+				if err != nil {
+					t.Fatalf("Decrypt() should not have raised an error %s", err)
+				}
+			}
+
+			if test.signed {
+				entity = verifySMIME(t, entity)
+			}
+
+			if !bytes.HasPrefix(entity, []byte("Content-Type: multipart/alternative;")) || !bytes.Contains(entity, []byte("<p>body</p>")) {
+				t.Errorf("Exec() wrote entity %q, want the rendered body", entity)
+			}
+		})
+	}
+}
+
+func TestValidateSMIME(t *testing.T) {
+	certPEM, keyPEM := smimeCertificate(t, "fakemail2@example.com", time.Now().Add(time.Hour))
+	expiredPEM, expiredKeyPEM := smimeCertificate(t, "fakemail2@example.com", time.Now().Add(-time.Hour))
+
+	tests := []struct {
+		name     string
+		smime    *SMIME
+		sendType string
+		bcc      []string
+		wantErr  error
+	}{
+		{
+			name:  "signing certificate and key",
+			smime: &SMIME{Cert: certPEM, Key: keyPEM},
+		},
+		{
+			name:  "disabled",
+			smime: &SMIME{},
+		},
+		{
+			name:    "signing certificate without key",
+			smime:   &SMIME{Cert: certPEM},
+			wantErr: ErrorInvalidSMIMEParam,
+		},
+		{
+			name:    "mismatched signing key",
+			smime:   &SMIME{Cert: certPEM, Key: expiredKeyPEM},
+			wantErr: ErrorInvalidSMIMEParam,
+		},
+		{
+			name:    "expired signing certificate",
+			smime:   &SMIME{Cert: expiredPEM, Key: expiredKeyPEM},
+			wantErr: ErrorInvalidSMIMEParam,
+		},
+		{
+			name:    "missing recipients",
+			smime:   &SMIME{Recipients: filepath.Join(t.TempDir(), "missing")},
+			wantErr: ErrorInvalidSMIMEParam,
+		},
+		{
+			name:    "empty recipients directory",
+			smime:   &SMIME{Recipients: t.TempDir()},
+			wantErr: ErrorInvalidSMIMEParam,
+		},
+		{
+			name:  "sign with bcc",
+			smime: &SMIME{Cert: certPEM, Key: keyPEM},
+			bcc:   []string{"hidden@example.com"},
+		},
+		{
+			name:    "encrypt with bcc",
+			smime:   &SMIME{Recipients: certPEM},
+			bcc:     []string{"hidden@example.com"},
+			wantErr: ErrorInvalidSMIMEParam,
+		},
+		{
+			name:     "sendgrid",
+			smime:    &SMIME{Cert: certPEM, Key: keyPEM},
+			sendType: "SendGrid",
+			wantErr:  ErrorInvalidSMIMEParam,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &Plugin{
+				Email: &email.Email{
+					To:      []string{"fakemail1@example.com"},
+					Bcc:     test.bcc,
+					From:    "fakemail2@example.com",
+					Subject: "subject",
+				},
+				SMIME:    test.smime,
+				SendType: test.sendType,
+				DryRun:   true,
+			}
+
+			if err := p.Validate(); !errors.Is(err, test.wantErr) {
+				t.Errorf("Validate() error = %v, wantErr = %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"time"
+
+	"github.com/jordan-wright/email"
+	"github.com/sirupsen/logrus"
+)
+
+// Timeouts represents the time limits for a single attempt to send an email.
+type Timeouts struct {
+	// Connect limits establishing the TCP connection
+	Connect time.Duration
+	// Command limits every read and write once connected,
+	// including the TLS handshake and each SMTP command
+	Command time.Duration
+	// Total limits the entire attempt
+	Total time.Duration
+}
+
+// delivery represents a single attempt to send a message over SMTP.
+// It records the phase of the conversation so errors and timeouts
+// can report where the attempt failed. Without implicit TLS the
+// connection is upgraded whenever the server offers STARTTLS, like
+// smtp.SendMail, and when TLS is required the attempt fails instead
+// of continuing if STARTTLS is not offered.
+type delivery struct {
+	addr       string
+	helo       string
+	implicit   bool
+	startTLS   bool
+	requireTLS bool
+	auth       smtp.Auth
+	tlsConfig  *tls.Config
+	timeouts   Timeouts
+	deadline   time.Time
+	phase      string
+}
+
+// envelope returns the SMTP sender and recipients of the email. The
+// sender is the Sender address when provided and the From address
+// otherwise, while the recipients include the To, Cc and Bcc addresses.
+func envelope(e *email.Email) (string, []string, error) {
+	sender := e.From
+	if len(e.Sender) > 0 {
+		sender = e.Sender
+	}
+
+	from, err := mail.ParseAddress(sender)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid sender %s: %w", sender, err)
+	}
+
+	var to []string
+
+	for _, list := range [][]string{e.To, e.Cc, e.Bcc} {
+		for _, recipient := range list {
+			addr, err := mail.ParseAddress(recipient)
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid recipient %s: %w", recipient, err)
+			}
+
+			to = append(to, addr.Address)
+		}
+	}
+
+	return from.Address, to, nil
+}
+
+// send runs the SMTP conversation delivering the message from the
+// sender to the recipients. The message is considered delivered once
+// the server accepts the data, so a failure to quit is only logged.
+func (d *delivery) send(ctx context.Context, from string, to []string, msg []byte) (err error) {
+	if d.timeouts.Total > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, d.timeouts.Total)
+		defer cancel()
+
+		d.deadline, _ = ctx.Deadline()
+	}
+
+	host, _, err := net.SplitHostPort(d.addr)
+	if err != nil {
+		return err
+	}
+
+	tlsConfig := d.tlsConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	}
+
+	d.phase = "connect"
+
+	dialer := &net.Dialer{Timeout: d.timeouts.Connect}
+
+	raw, err := dialer.DialContext(ctx, "tcp", d.addr)
+	if err != nil {
+		return d.fail(err)
+	}
+
+	var conn net.Conn = &deadlineConn{Conn: raw, timeout: d.timeouts.Command, deadline: d.deadline}
+	defer conn.Close()
+
+	if d.implicit {
+		d.phase = "tls handshake"
+
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return d.fail(err)
+		}
+
+		conn = tlsConn
+	}
+
+	d.phase = "greeting"
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return d.fail(err)
+	}
+
+	d.phase = "hello"
+
+	helo := d.helo
+	if len(helo) == 0 {
+		helo = "localhost"
+	}
+
+	if err := c.Hello(helo); err != nil {
+		return d.fail(err)
+	}
+
+	if !d.implicit {
+		ok, _ := c.Extension("STARTTLS")
+
+		switch {
+		case ok:
+			d.phase = "starttls"
+
+			if err := c.StartTLS(tlsConfig); err != nil {
+				return d.fail(err)
+			}
+		case d.requireTLS:
+			d.phase = "starttls"
+
+			return d.fail(fmt.Errorf("%w: %s does not support STARTTLS", ErrorTLSRequired, d.addr))
+		case d.startTLS:
+			logrus.Warnf("%s does not support STARTTLS, continuing without TLS", d.addr)
+		}
+	}
+
+	if d.auth != nil {
+		d.phase = "auth"
+
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return d.fail(fmt.Errorf("%w: %s", ErrorAuthUnsupported, d.addr))
+		}
+
+		if err := c.Auth(d.auth); err != nil {
+			return d.fail(err)
+		}
+	}
+
+	d.phase = "mail"
+
+	if err := c.Mail(from); err != nil {
+		return d.fail(err)
+	}
+
+	d.phase = "rcpt"
+
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return d.fail(fmt.Errorf("%s: %w", addr, err))
+		}
+	}
+
+	d.phase = "data"
+
+	w, err := c.Data()
+	if err != nil {
+		return d.fail(err)
+	}
+
+	if _, err := w.Write(msg); err != nil {
+		return d.fail(err)
+	}
+
+	if err := w.Close(); err != nil {
+		return d.fail(err)
+	}
+
+	d.phase = "quit"
+
+	if err := c.Quit(); err != nil {
+		logrus.Debugf("Message accepted by %s but quit failed: %v", d.addr, d.fail(err))
+	}
+
+	return nil
+}
+
+// fail labels the error with the phase of the conversation,
+// describing which time limit was reached for timeouts.
+func (d *delivery) fail(err error) error {
+	var netErr net.Error
+
+	if !errors.Is(err, context.DeadlineExceeded) && !(errors.As(err, &netErr) && netErr.Timeout()) {
+		return fmt.Errorf("%s: %w", d.phase, err)
+	}
+
+	switch {
+	case !d.deadline.IsZero() && !time.Now().Before(d.deadline):
+		return fmt.Errorf("%s timed out, total timeout of %s reached: %w", d.phase, d.timeouts.Total, err)
+	case d.phase == "connect":
+		return fmt.Errorf("%s timed out, connect timeout of %s reached: %w", d.phase, d.timeouts.Connect, err)
+	default:
+		return fmt.Errorf("%s timed out, command timeout of %s reached: %w", d.phase, d.timeouts.Command, err)
+	}
+}
+
+// deadlineConn is a net.Conn that extends its deadline before every
+// read and write so a stalled server fails the current command
+// instead of hanging, without exceeding the overall deadline.
+type deadlineConn struct {
+	net.Conn
+	timeout  time.Duration
+	deadline time.Time
+}
+
+// Read reads from the connection after extending its deadline.
+func (c *deadlineConn) Read(b []byte) (int, error) {
+	if err := c.SetDeadline(c.next()); err != nil {
+		return 0, err
+	}
+
+	return c.Conn.Read(b)
+}
+
+// Write writes to the connection after extending its deadline.
+func (c *deadlineConn) Write(b []byte) (int, error) {
+	if err := c.SetDeadline(c.next()); err != nil {
+		return 0, err
+	}
+
+	return c.Conn.Write(b)
+}
+
+// next returns the deadline for the next read or write.
+func (c *deadlineConn) next() time.Time {
+	var deadline time.Time
+
+	if c.timeout > 0 {
+		deadline = time.Now().Add(c.timeout)
+	}
+
+	if !c.deadline.IsZero() && (deadline.IsZero() || c.deadline.Before(deadline)) {
+		deadline = c.deadline
+	}
+
+	return deadline
+}
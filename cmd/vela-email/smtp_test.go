@@ -0,0 +1,560 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"net/textproto"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jordan-wright/email"
+)
+
+// fakeSMTP is a minimal SMTP server used to test sending emails.
+type fakeSMTP struct {
+	listener net.Listener
+	// tls is used for STARTTLS or, when implicit, for the whole connection
+	tls      *tls.Config
+	implicit bool
+	// extensions are advertised in reply to EHLO
+	extensions []string
+	// replies overrides the reply to a command such as "RCPT"
+	replies map[string]string
+	// stall stops replying once the command is received, "GREETING"
+	// stalls before the greeting is sent
+	stall string
+
+	mu       sync.Mutex
+	commands []string
+	messages []string
+	done     chan struct{}
+}
+
+// newFakeSMTP starts a fake SMTP server which is stopped with the test.
+func newFakeSMTP(t *testing.T, configure func(*fakeSMTP)) *fakeSMTP {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() should not have raised an error %s", err)
+	}
+
+	s := &fakeSMTP{
+		listener: listener,
+		replies:  map[string]string{},
+		done:     make(chan struct{}),
+	}
+
+	if configure != nil {
+		configure(s)
+	}
+
+	if s.implicit {
+		s.listener = tls.NewListener(listener, s.tls)
+	}
+
+	go s.serve()
+
+	t.Cleanup(func() {
+		close(s.done)
+		listener.Close()
+	})
+
+	return s
+}
+
+// addr returns the host:port of the server.
+func (s *fakeSMTP) addr() string {
+	return s.listener.Addr().String()
+}
+
+// port returns the port of the server.
+func (s *fakeSMTP) port() string {
+	_, port, _ := net.SplitHostPort(s.addr())
+
+	return port
+}
+
+// received returns the commands and messages received by the server.
+func (s *fakeSMTP) received() ([]string, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.commands), slices.Clone(s.messages)
+}
+
+func (s *fakeSMTP) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeSMTP) handle(conn net.Conn) {
+	defer conn.Close()
+
+	if s.stall == "GREETING" {
+		<-s.done
+
+		return
+	}
+
+	text := textproto.NewConn(conn)
+	_ = text.PrintfLine("220 fake ESMTP ready")
+
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+		s.mu.Lock()
+		s.commands = append(s.commands, line)
+		s.mu.Unlock()
+
+		if verb == s.stall {
+			<-s.done
+
+			return
+		}
+
+		if reply, ok := s.replies[verb]; ok {
+			_ = text.PrintfLine("%s", reply)
+
+			continue
+		}
+
+		switch verb {
+		case "EHLO":
+			extensions := slices.Clone(s.extensions)
+			if s.tls != nil && !s.implicit {
+				if _, ok := conn.(*tls.Conn); !ok {
+					extensions = append(extensions, "STARTTLS")
+				}
+			}
+
+			lines := append([]string{"fake"}, extensions...)
+			for i, ext := range lines {
+				sep := "-"
+				if i == len(lines)-1 {
+					sep = " "
+				}
+
+				_ = text.PrintfLine("250%s%s", sep, ext)
+			}
+		case "STARTTLS":
+			_ = text.PrintfLine("220 ready to start TLS")
+
+			tlsConn := tls.Server(conn, s.tls)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+
+			conn = tlsConn
+			text = textproto.NewConn(conn)
+		case "AUTH":
+			_ = text.PrintfLine("235 2.7.0 authentication successful")
+		case "DATA":
+			_ = text.PrintfLine("354 end data with <CR><LF>.<CR><LF>")
+
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+
+			s.mu.Lock()
+			s.messages = append(s.messages, string(data))
+			s.mu.Unlock()
+
+			_ = text.PrintfLine("250 2.0.0 queued")
+		case "QUIT":
+			_ = text.PrintfLine("221 2.0.0 bye")
+
+			return
+		default:
+			_ = text.PrintfLine("250 2.0.0 ok")
+		}
+	}
+}
+
+// testCertificate returns a self-signed certificate for
+// 127.0.0.1 and localhost along with a pool trusting it.
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() should not have raised an error %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate() should not have raised an error %s", err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate() should not have raised an error %s", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+func TestEnvelope(t *testing.T) {
+	e := &email.Email{
+		From: "Vela <vela@example.com>",
+		To:   []string{"one@example.com", "Two <two@example.com>"},
+		Cc:   []string{"three@example.com"},
+		Bcc:  []string{"four@example.com"},
+	}
+
+	from, to, err := envelope(e)
+	if err != nil {
+		t.Errorf("envelope() should not have raised an error %s", err)
+	}
+
+	if from != "vela@example.com" {
+		t.Errorf("envelope() sender is %s, want vela@example.com", from)
+	}
+
+	want := []string{"one@example.com", "two@example.com", "three@example.com", "four@example.com"}
+	if !slices.Equal(to, want) {
+		t.Errorf("envelope() recipients are %v, want %v", to, want)
+	}
+
+	e.Sender = "bounces@example.com"
+
+	if from, _, _ := envelope(e); from != "bounces@example.com" {
+		t.Errorf("envelope() sender is %s, want bounces@example.com", from)
+	}
+
+	e.Bcc = []string{"not an address"}
+
+	if _, _, err := envelope(e); err == nil {
+		t.Errorf("envelope() should have raised an error")
+	}
+}
+
+func TestSendTypes(t *testing.T) {
+	cert, pool := testCertificate(t)
+
+	serverTLS := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+
+	tests := []struct {
+		name      string
+		sendType  string
+		configure func(*fakeSMTP)
+		want      []string
+	}{
+		{
+			name:     "Plain",
+			sendType: "Plain",
+			want:     []string{"EHLO worker.example.com", "MAIL FROM:<fakemail2@example.com>", "RCPT TO:<fakemail1@example.com>", "RCPT TO:<hidden@example.com>", "DATA", "QUIT"},
+		},
+		{
+			name:      "StartTLS",
+			sendType:  "StartTLS",
+			configure: func(s *fakeSMTP) { s.tls = serverTLS },
+			want:      []string{"EHLO worker.example.com", "STARTTLS", "EHLO worker.example.com", "MAIL FROM:<fakemail2@example.com>", "RCPT TO:<fakemail1@example.com>", "RCPT TO:<hidden@example.com>", "DATA", "QUIT"},
+		},
+		{
+			name:      "TLS",
+			sendType:  "TLS",
+			configure: func(s *fakeSMTP) { s.tls, s.implicit = serverTLS, true },
+			want:      []string{"EHLO worker.example.com", "MAIL FROM:<fakemail2@example.com>", "RCPT TO:<fakemail1@example.com>", "RCPT TO:<hidden@example.com>", "DATA", "QUIT"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeSMTP(t, test.configure)
+
+			p := &Plugin{
+				Email: &email.Email{
+					To:      []string{"fakemail1@example.com"},
+					Bcc:     []string{"hidden@example.com"},
+					From:    "fakemail2@example.com",
+					Subject: "subject",
+					Text:    []byte("body"),
+				},
+				SMTPHost:  &SMTPHost{Hosts: []string{"127.0.0.1"}, Port: server.port()},
+				TLSConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+				HeloName:  "worker.example.com",
+				SendType:  test.sendType,
+				Timeouts:  &Timeouts{Connect: time.Second, Command: time.Second},
+				BuildEnv:  mockBuildEnv,
+			}
+
+			if err := p.Exec(); err != nil {
+				t.Errorf("Exec() should not have raised an error %s", err)
+			}
+
+			commands, messages := server.received()
+
+			if !slices.Equal(commands, test.want) {
+				t.Errorf("Exec() commands are %q, want %q", commands, test.want)
+			}
+
+			if len(messages) != 1 || !strings.Contains(messages[0], "Subject: subject") || strings.Contains(messages[0], "hidden@example.com") {
+				t.Errorf("Exec() messages are %q, want one message without the Bcc header", messages)
+			}
+		})
+	}
+}
+
+func TestSendTypeAuto(t *testing.T) {
+	cert, pool := testCertificate(t)
+
+	serverTLS := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+
+	tests := []struct {
+		name          string
+		sendType      string
+		configure     func(*fakeSMTP)
+		password      string
+		requireTLS    bool
+		allowInsecure bool
+		wantTLS       bool
+		wantErr       error
+	}{
+		{
+			name:      "upgrades when offered",
+			sendType:  "Auto",
+			configure: func(s *fakeSMTP) { s.tls = serverTLS },
+			wantTLS:   true,
+		},
+		{
+			name:     "continues without tls or credentials",
+			sendType: "Auto",
+		},
+		{
+			name:     "refuses credentials without tls",
+			sendType: "Auto",
+			password: "shh",
+			wantErr:  ErrorTLSRequired,
+		},
+		{
+			name:          "allows insecure credentials",
+			sendType:      "Auto",
+			password:      "shh",
+			allowInsecure: true,
+		},
+		{
+			name:       "starttls required",
+			sendType:   "StartTLS",
+			requireTLS: true,
+			wantErr:    ErrorTLSRequired,
+		},
+		{
+			name:      "plain upgrades when offered",
+			sendType:  "Plain",
+			configure: func(s *fakeSMTP) { s.tls = serverTLS },
+			password:  "shh",
+			wantTLS:   true,
+		},
+		{
+			name:     "plain continues without tls",
+			sendType: "Plain",
+		},
+		{
+			name:      "credentials without auth offered",
+			sendType:  "Plain",
+			configure: func(s *fakeSMTP) { s.tls, s.extensions = serverTLS, nil },
+			password:  "shh",
+			wantTLS:   true,
+			wantErr:   ErrorAuthUnsupported,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeSMTP(t, func(s *fakeSMTP) {
+				s.extensions = []string{"AUTH PLAIN"}
+
+				if test.configure != nil {
+					test.configure(s)
+				}
+			})
+
+			p := &Plugin{
+				Email: &email.Email{
+					To:      []string{"fakemail1@example.com"},
+					From:    "fakemail2@example.com",
+					Subject: "subject",
+					Text:    []byte("body"),
+				},
+				SMTPHost:          &SMTPHost{Hosts: []string{"127.0.0.1"}, Port: server.port()},
+				TLSConfig:         &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+				SendType:          test.sendType,
+				RequireTLS:        test.requireTLS,
+				AllowInsecureAuth: test.allowInsecure,
+				Timeouts:          &Timeouts{Connect: time.Second, Command: time.Second},
+				BuildEnv:          mockBuildEnv,
+			}
+
+			if len(test.password) > 0 {
+				p.Auth = "PlainAuth"
+				p.SMTPHost.Username, p.SMTPHost.Password = "vela", test.password
+			}
+
+			if err := p.Exec(); !errors.Is(err, test.wantErr) {
+				t.Errorf("Exec() error = %v, wantErr = %v", err, test.wantErr)
+			}
+
+			commands, _ := server.received()
+
+			if slices.Contains(commands, "STARTTLS") != test.wantTLS {
+				t.Errorf("Exec() commands are %q, want STARTTLS %v", commands, test.wantTLS)
+			}
+
+			if slices.Contains(commands, "DATA") != (test.wantErr == nil) {
+				t.Errorf("Exec() commands are %q, want the message sent %v", commands, test.wantErr == nil)
+			}
+		})
+	}
+}
+
+func TestHeloName(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatalf("Hostname() should not have raised an error %s", err)
+	}
+
+	tests := []struct {
+		name      string
+		heloName  string
+		buildHost string
+		want      string
+	}{
+		{name: "parameter", heloName: "mail.example.com", buildHost: "worker.example.com", want: "mail.example.com"},
+		{name: "build host", buildHost: "worker.example.com", want: "worker.example.com"},
+		{name: "hostname", want: hostname},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("VELA_BUILD_HOST", test.buildHost)
+
+			p := &Plugin{HeloName: test.heloName}
+
+			if got := p.heloName(); got != test.want {
+				t.Errorf("heloName() is %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSendErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(*fakeSMTP)
+		timeouts  Timeouts
+		wantCode  int
+		wantErr   string
+	}{
+		{
+			name:      "greeting stalls",
+			configure: func(s *fakeSMTP) { s.stall = "GREETING" },
+			timeouts:  Timeouts{Command: 100 * time.Millisecond},
+			wantErr:   "greeting timed out, command timeout of 100ms reached",
+		},
+		{
+			name:      "data stalls past total timeout",
+			configure: func(s *fakeSMTP) { s.stall = "DATA" },
+			timeouts:  Timeouts{Command: time.Second, Total: 200 * time.Millisecond},
+			wantErr:   "data timed out, total timeout of 200ms reached",
+		},
+		{
+			name:      "recipient rejected",
+			configure: func(s *fakeSMTP) { s.replies["RCPT"] = "550 5.1.1 user unknown" },
+			timeouts:  Timeouts{Command: time.Second},
+			wantCode:  550,
+			wantErr:   "rcpt: fakemail1@example.com: 550",
+		},
+		{
+			name:      "sender greylisted",
+			configure: func(s *fakeSMTP) { s.replies["MAIL"] = "451 4.7.1 greylisted" },
+			timeouts:  Timeouts{Command: time.Second},
+			wantCode:  451,
+			wantErr:   "mail: 451",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeSMTP(t, test.configure)
+
+			d := &delivery{addr: server.addr(), timeouts: test.timeouts}
+
+			err := d.send(context.Background(), "fakemail2@example.com", []string{"fakemail1@example.com"}, []byte("Subject: subject\r\n\r\nbody\r\n"))
+			if err == nil {
+				t.Fatalf("send() should have raised an error")
+			}
+
+			if !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("send() error is %q, want it to contain %q", err, test.wantErr)
+			}
+
+			if code := replyCode(err); code != test.wantCode {
+				t.Errorf("send() reply code is %d, want %d", code, test.wantCode)
+			}
+
+			if test.wantCode == 0 && !isTransient(err) {
+				t.Errorf("send() error %q should be transient", err)
+			}
+		})
+	}
+}
+
+func TestDeliveryFail(t *testing.T) {
+	timeout := &net.OpError{Op: "dial", Net: "tcp", Err: timeoutError{}}
+
+	d := &delivery{phase: "connect", timeouts: Timeouts{Connect: 10 * time.Second, Command: time.Minute}}
+
+	if err := d.fail(timeout); !strings.HasPrefix(err.Error(), "connect timed out, connect timeout of 10s reached") {
+		t.Errorf("fail() error is %q, want a connect timeout", err)
+	}
+
+	d.phase = "auth"
+
+	if err := d.fail(timeout); !strings.HasPrefix(err.Error(), "auth timed out, command timeout of 1m0s reached") {
+		t.Errorf("fail() error is %q, want a command timeout", err)
+	}
+
+	if err := d.fail(errors.New("boom")); err.Error() != "auth: boom" {
+		t.Errorf("fail() error is %q, want it labelled with the phase", err)
+	}
+}
@@ -0,0 +1,166 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// tlsVersions maps the supported minimum TLS versions to their values.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// configureTLS applies the CA certificates, minimum version, cipher
+// suites and client certificate to the TLS configuration.
+func (p *Plugin) configureTLS() error {
+	logrus.Trace("entered plugin.configureTLS")
+	defer logrus.Trace("exited plugin.configureTLS")
+
+	if p.TLSConfig == nil {
+		p.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	if err := p.loadCACert(); err != nil {
+		return err
+	}
+
+	if len(p.TLSMinVersion) > 0 {
+		version, ok := tlsVersions[strings.TrimPrefix(strings.ToLower(p.TLSMinVersion), "tls")]
+		if !ok {
+			return fmt.Errorf("%w: unknown tls_min_version %s", ErrorInvalidTLSParam, p.TLSMinVersion)
+		}
+
+		if version < tls.VersionTLS12 {
+			logrus.Warnf("Allowing deprecated TLS %s connections", p.TLSMinVersion)
+		}
+
+		p.TLSConfig.MinVersion = version
+	}
+
+	if len(p.CipherSuites) > 0 {
+		suites, err := cipherSuites(p.CipherSuites)
+		if err != nil {
+			return err
+		}
+
+		p.TLSConfig.CipherSuites = suites
+	}
+
+	return p.loadClientCertificate()
+}
+
+// loadCACert appends the CA certificates to the system
+// certificate pool used to verify the relays.
+func (p *Plugin) loadCACert() error {
+	if len(p.CACert) == 0 {
+		return nil
+	}
+
+	data, err := readPEM(p.CACert)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrorInvalidCACert, err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		logrus.Debugf("Unable to load the system certificate pool, using only the provided CA: %v", err)
+
+		pool = x509.NewCertPool()
+	}
+
+	if !pool.AppendCertsFromPEM(data) {
+		return fmt.Errorf("%w: no certificates found", ErrorInvalidCACert)
+	}
+
+	p.TLSConfig.RootCAs = pool
+
+	return nil
+}
+
+// cipherSuites returns the IDs of the named cipher suites. Only the
+// suites Go considers secure are allowed and they apply to TLS 1.2
+// and below, as TLS 1.3 suites are not configurable.
+func cipherSuites(names []string) ([]uint16, error) {
+	known := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	var ids []uint16
+
+	for _, name := range names {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if len(name) == 0 {
+			continue
+		}
+
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown or insecure cipher suite %s", ErrorInvalidTLSParam, name)
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// loadClientCertificate adds the client certificate and key
+// to the TLS configuration for relays that require mutual TLS.
+func (p *Plugin) loadClientCertificate() error {
+	logrus.Trace("entered plugin.loadClientCertificate")
+	defer logrus.Trace("exited plugin.loadClientCertificate")
+
+	if len(p.ClientCert) == 0 && len(p.ClientKey) == 0 {
+		return nil
+	}
+
+	if len(p.ClientCert) == 0 || len(p.ClientKey) == 0 {
+		return fmt.Errorf("%w: both a certificate and a key are required", ErrorInvalidClientCert)
+	}
+
+	certPEM, err := readPEM(p.ClientCert)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrorInvalidClientCert, err)
+	}
+
+	keyPEM, err := readPEM(p.ClientKey)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrorInvalidClientCert, err)
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrorInvalidClientCert, err)
+	}
+
+	if p.TLSConfig == nil {
+		p.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	logrus.Debugf("Using client certificate for %s", cert.Leaf.Subject)
+
+	p.TLSConfig.Certificates = []tls.Certificate{cert}
+
+	return nil
+}
+
+// readPEM returns the PEM data when it is provided
+// inline or otherwise reads it from the file.
+func readPEM(value string) ([]byte, error) {
+	if strings.Contains(value, "-----BEGIN") {
+		return []byte(value), nil
+	}
+
+	return os.ReadFile(value)
+}
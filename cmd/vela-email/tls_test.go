@@ -0,0 +1,260 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jordan-wright/email"
+)
+
+// pemEncode returns the certificate and private key as PEM.
+func pemEncode(t *testing.T, cert tls.Certificate) (string, string) {
+	t.Helper()
+
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey() should not have raised an error %s", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})
+
+	return string(certPEM), string(keyPEM)
+}
+
+func TestLoadClientCertificate(t *testing.T) {
+	cert, _ := testCertificate(t)
+	certPEM, keyPEM := pemEncode(t, cert)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+
+	if err := os.WriteFile(certFile, []byte(certPEM), 0o600); err != nil {
+		t.Fatalf("WriteFile() should not have raised an error %s", err)
+	}
+
+	if err := os.WriteFile(keyFile, []byte(keyPEM), 0o600); err != nil {
+		t.Fatalf("WriteFile() should not have raised an error %s", err)
+	}
+
+	tests := []struct {
+		name    string
+		cert    string
+		key     string
+		wantErr error
+	}{
+		{name: "none"},
+		{name: "inline", cert: certPEM, key: keyPEM},
+		{name: "files", cert: certFile, key: keyFile},
+		{name: "mixed", cert: certFile, key: keyPEM},
+		{name: "missing key", cert: certPEM, wantErr: ErrorInvalidClientCert},
+		{name: "missing file", cert: filepath.Join(dir, "missing.crt"), key: keyFile, wantErr: os.ErrNotExist},
+		{name: "mismatched", cert: keyPEM, key: certPEM, wantErr: ErrorInvalidClientCert},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &Plugin{ClientCert: test.cert, ClientKey: test.key}
+
+			err := p.loadClientCertificate()
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("loadClientCertificate() error = %v, wantErr = %v", err, test.wantErr)
+			}
+
+			if err != nil || len(test.cert) == 0 {
+				return
+			}
+
+			if len(p.TLSConfig.Certificates) != 1 {
+				t.Errorf("loadClientCertificate() loaded %d certificates, want 1", len(p.TLSConfig.Certificates))
+			}
+		})
+	}
+}
+
+func TestExecClientCertificate(t *testing.T) {
+	cert, pool := testCertificate(t)
+	certPEM, keyPEM := pemEncode(t, cert)
+
+	serverTLS := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	for _, sendType := range []string{"StartTLS", "TLS"} {
+		t.Run(sendType, func(t *testing.T) {
+			server := newFakeSMTP(t, func(s *fakeSMTP) {
+				s.tls, s.implicit = serverTLS, sendType == "TLS"
+			})
+
+			p := &Plugin{
+				Email: &email.Email{
+					To:      []string{"fakemail1@example.com"},
+					From:    "fakemail2@example.com",
+					Subject: "subject",
+					Text:    []byte("body"),
+				},
+				SMTPHost:   &SMTPHost{Hosts: []string{"127.0.0.1"}, Port: server.port()},
+				TLSConfig:  &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+				SendType:   sendType,
+				Timeouts:   &Timeouts{Connect: time.Second, Command: time.Second},
+				BuildEnv:   mockBuildEnv,
+				ClientCert: certPEM,
+				ClientKey:  keyPEM,
+			}
+
+			if err := p.Validate(); err != nil {
+				t.Errorf("Validate() should not have raised an error %s", err)
+			}
+
+			if err := p.Exec(); err != nil {
+				t.Errorf("Exec() should not have raised an error %s", err)
+			}
+
+			if commands, _ := server.received(); !slices.Contains(commands, "DATA") {
+				t.Errorf("Exec() commands are %q, want the message sent", commands)
+			}
+
+			// without the client certificate the relay rejects the handshake
+			p.TLSConfig.Certificates = nil
+
+			if err := p.Exec(); err == nil {
+				t.Errorf("Exec() should have raised an error without a client certificate")
+			}
+		})
+	}
+}
+
+func TestConfigureTLS(t *testing.T) {
+	cert, _ := testCertificate(t)
+	certPEM, _ := pemEncode(t, cert)
+
+	tests := []struct {
+		name        string
+		plugin      Plugin
+		wantVersion uint16
+		wantSuites  []uint16
+		wantErr     error
+	}{
+		{
+			name:        "defaults",
+			wantVersion: tls.VersionTLS12,
+		},
+		{
+			name:        "ca and policy",
+			plugin:      Plugin{CACert: certPEM, TLSMinVersion: "TLS1.3", CipherSuites: []string{"tls_ecdhe_rsa_with_aes_128_gcm_sha256", " "}},
+			wantVersion: tls.VersionTLS13,
+			wantSuites:  []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		},
+		{
+			name:    "ca without certificates",
+			plugin:  Plugin{CACert: "-----BEGIN CERTIFICATE-----\nnope\n-----END CERTIFICATE-----"},
+			wantErr: ErrorInvalidCACert,
+		},
+		{
+			name:    "ca file missing",
+			plugin:  Plugin{CACert: "testdata/missing.pem"},
+			wantErr: os.ErrNotExist,
+		},
+		{
+			name:    "unknown version",
+			plugin:  Plugin{TLSMinVersion: "1.4"},
+			wantErr: ErrorInvalidTLSParam,
+		},
+		{
+			name:    "insecure cipher suite",
+			plugin:  Plugin{CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+			wantErr: ErrorInvalidTLSParam,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := test.plugin
+
+			err := p.configureTLS()
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("configureTLS() error = %v, wantErr = %v", err, test.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if p.TLSConfig.MinVersion != test.wantVersion {
+				t.Errorf("configureTLS() MinVersion is %x, want %x", p.TLSConfig.MinVersion, test.wantVersion)
+			}
+
+			if !slices.Equal(p.TLSConfig.CipherSuites, test.wantSuites) {
+				t.Errorf("configureTLS() CipherSuites are %v, want %v", p.TLSConfig.CipherSuites, test.wantSuites)
+			}
+
+			if (len(p.CACert) > 0) != (p.TLSConfig.RootCAs != nil) {
+				t.Errorf("configureTLS() RootCAs is %v, want it set only with a CA", p.TLSConfig.RootCAs)
+			}
+		})
+	}
+}
+
+func TestExecCACert(t *testing.T) {
+	cert, _ := testCertificate(t)
+	certPEM, _ := pemEncode(t, cert)
+
+	server := newFakeSMTP(t, func(s *fakeSMTP) {
+		s.tls = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12, MaxVersion: tls.VersionTLS12}
+	})
+
+	newPlugin := func(caCert, minVersion string) *Plugin {
+		return &Plugin{
+			Email: &email.Email{
+				To:      []string{"fakemail1@example.com"},
+				From:    "fakemail2@example.com",
+				Subject: "subject",
+				Text:    []byte("body"),
+			},
+			SMTPHost:      &SMTPHost{Hosts: []string{"127.0.0.1"}, Port: server.port()},
+			TLSConfig:     &tls.Config{MinVersion: tls.VersionTLS12},
+			SendType:      "StartTLS",
+			Timeouts:      &Timeouts{Connect: time.Second, Command: time.Second},
+			BuildEnv:      mockBuildEnv,
+			CACert:        caCert,
+			TLSMinVersion: minVersion,
+		}
+	}
+
+	tests := []struct {
+		name       string
+		caCert     string
+		minVersion string
+		wantErr    bool
+	}{
+		{name: "trusted ca", caCert: certPEM, minVersion: "1.2"},
+		{name: "untrusted ca", minVersion: "1.2", wantErr: true},
+		{name: "server below minimum version", caCert: certPEM, minVersion: "1.3", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := newPlugin(test.caCert, test.minVersion)
+
+			if err := p.Validate(); err != nil {
+				t.Errorf("Validate() should not have raised an error %s", err)
+			}
+
+			if err := p.Exec(); (err != nil) != test.wantErr {
+				t.Errorf("Exec() error = %v, wantErr = %v", err, test.wantErr)
+			}
+		})
+	}
+}